@@ -0,0 +1,101 @@
+package boxbuf
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownRecipient is returned by AddressBook.Lookup when no entry has the
+// requested name.
+var ErrUnknownRecipient = errors.New("no address book entry with that name")
+
+// An AddressBook maps human readable names, such as "alice" or
+// "alice@example.com", to recipient public keys. Names are case
+// insensitive.
+type AddressBook struct {
+	entries map[string][32]byte
+}
+
+// NewAddressBook creates an empty AddressBook.
+func NewAddressBook() *AddressBook {
+	return &AddressBook{
+		entries: make(map[string][32]byte),
+	}
+}
+
+// normalizeName returns the form of name used as a map key.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Add maps name to publicKey, replacing any existing entry.
+func (a *AddressBook) Add(name string, publicKey [32]byte) error {
+	name = normalizeName(name)
+	if name == "" {
+		return errors.New("address book names must not be empty")
+	}
+	a.entries[name] = publicKey
+	return nil
+}
+
+// Remove deletes the entry for name, if any.
+func (a *AddressBook) Remove(name string) {
+	delete(a.entries, normalizeName(name))
+}
+
+// Lookup returns the public key for name.
+func (a *AddressBook) Lookup(name string) ([32]byte, error) {
+	publicKey, ok := a.entries[normalizeName(name)]
+	if !ok {
+		return [32]byte{}, ErrUnknownRecipient
+	}
+	return publicKey, nil
+}
+
+// Complete returns the sorted names beginning with prefix, for use in shell
+// completion and other interactive interfaces.
+func (a *AddressBook) Complete(prefix string) []string {
+	prefix = normalizeName(prefix)
+	var names []string
+	for name := range a.entries {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save writes the address book to w as JSON.
+func (a *AddressBook) Save(w io.Writer) error {
+	encoded := make(map[string][]byte, len(a.entries))
+	for name, publicKey := range a.entries {
+		encoded[name] = append([]byte(nil), publicKey[:]...)
+	}
+	return json.NewEncoder(w).Encode(encoded)
+}
+
+// LoadAddressBook reads an address book written by AddressBook.Save from r.
+func LoadAddressBook(r io.Reader) (*AddressBook, error) {
+	var encoded map[string][]byte
+	err := json.NewDecoder(r).Decode(&encoded)
+	if err != nil {
+		return nil, err
+	}
+	a := NewAddressBook()
+	for name, publicKey := range encoded {
+		if len(publicKey) != 32 {
+			return nil, errors.New("malformed public key in address book")
+		}
+		var key [32]byte
+		copy(key[:], publicKey)
+		err = a.Add(name, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,48 @@
+package boxbuf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestAddressBook verifies address book lookups, completion, and
+// persistence.
+func TestAddressBook(t *testing.T) {
+	a := NewAddressBook()
+	alice, bob := [32]byte{1}, [32]byte{2}
+	if err := a.Add("Alice@example.com", alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Add("alex", bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Add("  ", bob); err == nil {
+		t.Fatal("expected error adding an empty name")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := a.Save(buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadAddressBook(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := loaded.Lookup("alice@EXAMPLE.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != alice {
+		t.Fatal("wrong key for alice")
+	}
+	if names := loaded.Complete("Al"); !reflect.DeepEqual(names, []string{"alex", "alice@example.com"}) {
+		t.Fatal("unexpected completions", names)
+	}
+
+	loaded.Remove("alex")
+	if _, err := loaded.Lookup("alex"); err != ErrUnknownRecipient {
+		t.Fatal("expected ErrUnknownRecipient, got", err)
+	}
+}
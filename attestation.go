@@ -0,0 +1,137 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// attestationPrefix domain separates key attestation signatures from any
+// other use of the root key.
+const attestationPrefix = "boxbuf key attestation"
+
+var (
+	// ErrInvalidAttestation is returned when a key attestation was not signed
+	// by the expected root key.
+	ErrInvalidAttestation = errors.New("invalid key attestation signature")
+	// ErrAttestationExpired is returned when a key attestation is used after
+	// its expiry time.
+	ErrAttestationExpired = errors.New("key attestation has expired")
+)
+
+// KeyAttestation is a statement, signed by an organization's Ed25519 root
+// key, that PublicKey belongs to Name until Expires. Recipients holding an
+// attestation can be trusted through the root key instead of pinning each
+// public key individually.
+type KeyAttestation struct {
+	PublicKey [32]byte
+	Name      string
+	Expires   time.Time
+	Signature []byte
+}
+
+// AttestKey signs an attestation binding publicKey to name until expires
+// using rootKey.
+func AttestKey(rootKey ed25519.PrivateKey, publicKey [32]byte, name string, expires time.Time) *KeyAttestation {
+	a := &KeyAttestation{
+		PublicKey: publicKey,
+		Name:      name,
+		Expires:   time.Unix(expires.Unix(), 0),
+	}
+	a.Signature = ed25519.Sign(rootKey, a.signedMessage())
+	return a
+}
+
+// signedMessage returns the bytes covered by the attestation's signature.
+func (a *KeyAttestation) signedMessage() []byte {
+	var b bytes.Buffer
+	b.WriteString(attestationPrefix)
+	b.Write(a.PublicKey[:])
+	binary.Write(&b, binary.LittleEndian, a.Expires.Unix())
+	binary.Write(&b, binary.LittleEndian, uint64(len(a.Name)))
+	b.WriteString(a.Name)
+	return b.Bytes()
+}
+
+// Verify checks that the attestation was signed by rootPublicKey and has not
+// expired at time now.
+func (a *KeyAttestation) Verify(rootPublicKey ed25519.PublicKey, now time.Time) error {
+	if !ed25519.Verify(rootPublicKey, a.signedMessage(), a.Signature) {
+		return ErrInvalidAttestation
+	}
+	if !now.Before(a.Expires) {
+		return ErrAttestationExpired
+	}
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (a *KeyAttestation) MarshalBinary() ([]byte, error) {
+	if len(a.Signature) != ed25519.SignatureSize {
+		return nil, errors.New("key attestation is not signed")
+	}
+	return append(a.signedMessage(), a.Signature...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It does not verify
+// the attestation; call Verify before trusting the result.
+func (a *KeyAttestation) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	prefix := make([]byte, len(attestationPrefix))
+	_, err := io.ReadFull(r, prefix)
+	if err != nil {
+		return err
+	}
+	if string(prefix) != attestationPrefix {
+		return errors.New("not a key attestation")
+	}
+	var publicKey [32]byte
+	_, err = io.ReadFull(r, publicKey[:])
+	if err != nil {
+		return err
+	}
+	var expires int64
+	err = binary.Read(r, binary.LittleEndian, &expires)
+	if err != nil {
+		return err
+	}
+	var nameLength uint64
+	err = binary.Read(r, binary.LittleEndian, &nameLength)
+	if err != nil {
+		return err
+	}
+	if r.Len() < ed25519.SignatureSize || nameLength != uint64(r.Len()-ed25519.SignatureSize) {
+		return errors.New("malformed key attestation")
+	}
+	name := make([]byte, nameLength)
+	_, err = io.ReadFull(r, name)
+	if err != nil {
+		return err
+	}
+	signature := make([]byte, ed25519.SignatureSize)
+	_, err = io.ReadFull(r, signature)
+	if err != nil {
+		return err
+	}
+	*a = KeyAttestation{
+		PublicKey: publicKey,
+		Name:      string(name),
+		Expires:   time.Unix(expires, 0),
+		Signature: signature,
+	}
+	return nil
+}
+
+// NewAttestedWriter verifies that a was signed by rootPublicKey and is still
+// valid, then initializes a new EncWriter encrypting to the attested public
+// key.
+func NewAttestedWriter(a *KeyAttestation, rootPublicKey ed25519.PublicKey, out io.Writer) (*EncWriter, error) {
+	err := a.Verify(rootPublicKey, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(a.PublicKey, out)
+}
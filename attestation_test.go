@@ -0,0 +1,100 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestKeyAttestation verifies that key attestations round trip through their
+// binary encoding and are rejected when forged, tampered with, or expired.
+func TestKeyAttestation(t *testing.T) {
+	rootPublicKey, rootKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := AttestKey(rootKey, *pk, "alice@example.com", time.Now().Add(time.Hour))
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded KeyAttestation
+	err = decoded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.PublicKey != *pk || decoded.Name != "alice@example.com" || !decoded.Expires.Equal(a.Expires) {
+		t.Fatal("attestation did not round trip")
+	}
+	err = decoded.Verify(rootPublicKey, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewAttestedWriter(&decoded, rootPublicKey, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded.Name = "mallory@example.com"
+	if err := decoded.Verify(rootPublicKey, time.Now()); err != ErrInvalidAttestation {
+		t.Fatal("expected ErrInvalidAttestation for tampered name, got", err)
+	}
+
+	otherRoot, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Verify(otherRoot, time.Now()); err != ErrInvalidAttestation {
+		t.Fatal("expected ErrInvalidAttestation for wrong root, got", err)
+	}
+
+	if err := a.Verify(rootPublicKey, time.Now().Add(2*time.Hour)); err != ErrAttestationExpired {
+		t.Fatal("expected ErrAttestationExpired, got", err)
+	}
+}
+
+// TestKeyAttestationMalformed verifies that UnmarshalBinary rejects
+// truncated and malformed attestations without panicking.
+func TestKeyAttestationMalformed(t *testing.T) {
+	_, rootKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := AttestKey(rootKey, *pk, "alice@example.com", time.Now().Add(time.Hour)).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(data); n++ {
+		var decoded KeyAttestation
+		if decoded.UnmarshalBinary(data[:n]) == nil {
+			t.Fatal("truncated attestation of", n, "bytes was accepted")
+		}
+	}
+
+	// a name length matching the remaining bytes minus a signature that
+	// doesn't fit.
+	malformed := data[:len(attestationPrefix)+32+8]
+	remaining := 10
+	malformed = append(malformed, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(malformed[len(malformed)-8:], uint64(remaining-ed25519.SignatureSize))
+	malformed = append(malformed, make([]byte, remaining)...)
+	var decoded KeyAttestation
+	if decoded.UnmarshalBinary(malformed) == nil {
+		t.Fatal("malformed attestation was accepted")
+	}
+}
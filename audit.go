@@ -0,0 +1,53 @@
+package boxbuf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// AuditEvent describes one attempt by a DecReader to decrypt a block of a
+// stream. Events are delivered for every block that is read, whether or not
+// it decrypts successfully; reaching the clean end of the stream does not
+// produce an event.
+type AuditEvent struct {
+	// KeyFingerprint identifies the recipient key used for decryption, as
+	// returned by KeyFingerprint.
+	KeyFingerprint string
+	// SenderPublicKey is the public key stored at the start of the stream.
+	SenderPublicKey [32]byte
+	// StreamID identifies the stream being decrypted.
+	StreamID StreamID
+	// Block is the index of the block within the stream.
+	Block uint64
+	// CiphertextBytes is the number of bytes read for the block, including
+	// its nonce and length prefix.
+	CiphertextBytes int
+	// PlaintextBytes is the number of bytes the block decrypted to.
+	PlaintextBytes int
+	// Err is nil if the block was read and decrypted successfully. It is
+	// ErrReplayedStream if the block decrypted but the stream was refused by
+	// the DecReader's ReplayCache.
+	Err error
+}
+
+// KeyFingerprint returns a short, printable identifier for publicKey
+// suitable for logs.
+func KeyFingerprint(publicKey [32]byte) string {
+	sum := sha256.Sum256(publicKey[:])
+	return hex.EncodeToString(sum[:8])
+}
+
+// SetAuditFunc causes fn to be called with an AuditEvent each time the
+// DecReader attempts to decrypt a block, so that services can feed
+// decryption activity into audit logs. fn is called synchronously from Read
+// and must not call back into the DecReader. A nil fn disables auditing.
+func (b *DecReader) SetAuditFunc(fn func(AuditEvent)) {
+	b.audit = fn
+	if fn != nil && b.keyFingerprint == "" {
+		var publicKey [32]byte
+		curve25519.ScalarBaseMult(&publicKey, &b.secretKey)
+		b.keyFingerprint = KeyFingerprint(publicKey)
+	}
+}
@@ -0,0 +1,75 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestAuditFunc verifies that a DecReader reports successful and failed
+// block decryptions to its audit function.
+func TestAuditFunc(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write([]byte("this is another test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := result.Bytes()
+	stream[len(stream)-1] ^= 1
+
+	decReader, err := NewReader(*sk, bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []AuditEvent
+	decReader.SetAuditFunc(func(e AuditEvent) {
+		events = append(events, e)
+	})
+	_, err = decReader.Read(make([]byte, len("this is a testthis is another test")))
+	if err == nil {
+		t.Fatal("expected tampered block to fail decryption")
+	}
+
+	if len(events) != 2 {
+		t.Fatal("expected 2 audit events, got", len(events))
+	}
+	if events[0].Err != nil || events[0].Block != 0 || events[0].PlaintextBytes != len("this is a test") {
+		t.Fatal("unexpected event for first block", events[0])
+	}
+	if events[1].Err == nil || events[1].Block != 1 || events[1].PlaintextBytes != 0 {
+		t.Fatal("unexpected event for tampered block", events[1])
+	}
+	for _, e := range events {
+		if e.KeyFingerprint != KeyFingerprint(*pk) {
+			t.Fatal("audit event has wrong key fingerprint")
+		}
+		if e.StreamID != encWriter.StreamID() {
+			t.Fatal("audit event has wrong stream ID")
+		}
+		if e.CiphertextBytes != 24+8+blockHeaderSize+box.Overhead+e.PlaintextBytes && e.Err == nil {
+			t.Fatal("audit event has wrong ciphertext size")
+		}
+	}
+}
@@ -2,10 +2,15 @@ package boxbuf
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
 
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
 	"golang.org/x/crypto/nacl/box"
 )
 
@@ -13,115 +18,514 @@ import (
 // new block is written
 const maxBlockSize = 16384 // 16 kb
 
-// EncWriter is an io.Writer that can be used to encrypt data with a peer's
+// streamMagic starts every stream, so that readers can tell versioned
+// streams from the legacy format, which began directly with the sender's
+// public key.
+const streamMagic = "boxbuf"
+
+// streamVersion is the version of the stream format written by EncWriter. It
+// follows streamMagic in the stream header and is incremented whenever the
+// framing changes.
+const streamVersion = 2
+
+// streamSaltSize is the size of the random salt in the stream header.
+const streamSaltSize = 32
+
+// streamHeaderSize is the size of the stream header: the magic, the version,
+// the sender's public key and the stream's salt.
+const streamHeaderSize = len(streamMagic) + 1 + 32 + streamSaltSize
+
+// streamKeyInfo domain separates the key derived for each stream from other
+// uses of the sender and recipient's shared key.
+const streamKeyInfo = "boxbuf stream key"
+
+// blockHeaderSize is the size of the header sealed at the start of every
+// block: the little endian index of the block followed by a flags byte.
+const blockHeaderSize = 9
+
+// finalBlockFlag marks the last block of a stream.
+const finalBlockFlag = 1
+
+// nonceInfoPrefix domain separates derived block nonces from other uses of
+// a stream's shared key.
+const nonceInfoPrefix = "boxbuf nonce"
+
+// ErrEntropyUnavailable is returned when the system's secure random number
+// generator fails. Such failures are usually transient (for example, early
+// in boot before the kernel has gathered entropy), so callers may retry the
+// operation after a delay. When returned from Write, the EncWriter remains
+// usable and any data not yet written is still buffered.
+var ErrEntropyUnavailable = errors.New("could not read entropy for encryption")
+
+// ErrWriterClosed is returned by Write and Flush after an EncWriter has been
+// closed.
+var ErrWriterClosed = errors.New("write to closed EncWriter")
+
+// ErrReaderClosed is returned by DecReader methods that need the stream's key
+// after the DecReader has been closed.
+var ErrReaderClosed = errors.New("use of closed DecReader")
+
+// EncWriter is an io.WriteCloser that can be used to encrypt data with a peer's
 // public key. EncWriter uses golang.org/x/crypto/nacl/box to perform
-// asymmetric encryption.
+// asymmetric encryption. Buffered plaintext is zeroed as soon as it has been
+// sealed.
 type EncWriter struct {
-	out io.Writer
-	buf []byte
+	out    io.Writer
+	buf    []byte
+	blocks uint64
+	rand   io.Reader
+
+	syncInterval   uint64
+	checkpoint     func(blocks uint64)
+	derivedNonces  bool
+	transformer    BlockTransformer
+	policy         func(head []byte) error
+	ciphertextHash hash.Hash
+	finalWritten   bool
+	err            error
 
+	header         []byte
+	salt           []byte
 	publicKey      [32]byte
 	secretKey      [32]byte
 	peersPublicKey [32]byte
+	sharedKey      [32]byte
 }
 
 // DecReader is an io.Reader that can be used to decrypt data using a secret
 // key. DecWriter uses golang.org/x/crypto/nacl/box to perform asymmetric
-// decryption.
+// decryption. Decrypted blocks are zeroed when they are replaced by the next
+// block and on Close.
 type DecReader struct {
-	in    io.Reader
-	buf   []byte
-	index int
+	in         io.Reader
+	buf        []byte
+	ciphertext []byte
+	index      int
+	blocks     uint64
+	offset     int64
+	final      bool
+	legacy     bool
+	salt       []byte
+	closed     bool
+
+	audit          func(AuditEvent)
+	keyFingerprint string
+	replayCache    ReplayCache
+	transformer    BlockTransformer
 
 	secretKey      [32]byte
 	peersPublicKey [32]byte
+	sharedKey      [32]byte
 }
 
 // NewWriter intializes a new EncWriter using peersPublicKey to encrypt all
-// data, writing the result to `out`.
+// data, writing the result to `out`. Each stream is written with a fresh
+// ephemeral keypair, so the public key at the start of the stream does not
+// link it to any other stream.
 func NewWriter(peersPublicKey [32]byte, out io.Writer) (*EncWriter, error) {
-	// TODO: naming here (pk vs peersPublicKey, need consistent naming)
-	// TODO: is this the optimal API? it seems very opinionated. one might want
-	// to pass the sender keypair, for example.
-	pk, sk, err := box.GenerateKey(rand.Reader)
+	return NewWriterWithRand(peersPublicKey, out, rand.Reader)
+}
+
+// NewWriterWithRand is like NewWriter, but reads the ephemeral keypair, the
+// stream salt and all block nonces from random instead of crypto/rand. It
+// has two uses. In production, random is crypto/rand wrapped by
+// NewHealthCheckedReader, so a generator that breaks at runtime is caught
+// before it weakens a stream. In tests, given the same random bytes and
+// plaintext writes it produces byte-for-byte identical output, which is
+// useful for golden fixtures. Whatever the use, random must be a
+// cryptographically secure source outside of tests; reusing its output
+// across streams breaks the confidentiality of both.
+func NewWriterWithRand(peersPublicKey [32]byte, out io.Writer, random io.Reader) (*EncWriter, error) {
+	publicKey, secretKey, err := box.GenerateKey(random)
 	if err != nil {
-		panic("could not generate keys for encryption")
+		return nil, entropyError(err)
 	}
-	_, err = out.Write(pk[:])
+	return newWriter(*publicKey, *secretKey, peersPublicKey, out, random)
+}
+
+// NewWriterWithKeys is like NewWriter, but encrypts with the caller's
+// long-term senderSecretKey instead of an ephemeral keypair. The matching
+// public key is written at the start of the stream, and since every block is
+// authenticated with the shared key of both keypairs, a reader that checks
+// DecReader.SenderPublicKey against a known key knows the stream came from
+// the holder of senderSecretKey (or from the recipient, who can compute the
+// same shared key). Each stream is still sealed with its own key, derived
+// from a random salt, so blocks can't be moved between streams sent between
+// the same two keys. The sender's public key is not encrypted, so anyone who
+// sees the streams can tell which were written with the same
+// senderSecretKey, and by whom if the key is known. Use NewWriter where
+// streams must not be linkable to their sender.
+func NewWriterWithKeys(senderSecretKey, peersPublicKey [32]byte, out io.Writer) (*EncWriter, error) {
+	var publicKey [32]byte
+	curve25519.ScalarBaseMult(&publicKey, &senderSecretKey)
+	return newWriter(publicKey, senderSecretKey, peersPublicKey, out, rand.Reader)
+}
+
+// newWriter writes the stream header for publicKey and a random salt to out
+// and returns an EncWriter sealing blocks with a key derived from the salt
+// and the shared key of secretKey and peersPublicKey.
+func newWriter(publicKey, secretKey, peersPublicKey [32]byte, out io.Writer, random io.Reader) (*EncWriter, error) {
+	salt := make([]byte, streamSaltSize)
+	_, err := io.ReadFull(random, salt)
+	if err != nil {
+		return nil, entropyError(err)
+	}
+	header := make([]byte, 0, streamHeaderSize)
+	header = append(header, streamMagic...)
+	header = append(header, streamVersion)
+	header = append(header, publicKey[:]...)
+	header = append(header, salt...)
+	_, err = out.Write(header)
 	if err != nil {
 		return nil, err
 	}
-	return &EncWriter{
+	w := &EncWriter{
+		header:         header,
+		salt:           salt,
 		peersPublicKey: peersPublicKey,
-		publicKey:      *pk,
-		secretKey:      *sk,
+		publicKey:      publicKey,
+		secretKey:      secretKey,
 		out:            out,
-	}, nil
+		buf:            make([]byte, 0, maxBlockSize),
+		rand:           random,
+	}
+	// the shared key is computed once per stream rather than once per block.
+	box.Precompute(&w.sharedKey, &w.peersPublicKey, &w.secretKey)
+	err = deriveStreamKey(&w.sharedKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
 }
 
 // NewReader creates a new DecReader using secretKey to decrypt the data as
-// needed from in.
+// needed from in. The stream does not depend on its absolute position, so a
+// stream embedded in a larger object can be decrypted by passing an
+// io.SectionReader covering just that stream. If the stream has no version
+// header NewReader returns ErrLegacyFormat, and if it has a version this
+// package can't read, ErrUnsupportedVersion. In both cases the start of the
+// stream has been consumed.
 func NewReader(secretKey [32]byte, in io.Reader) (*DecReader, error) {
+	var prefix [len(streamMagic) + 1]byte
+	_, err := io.ReadFull(in, prefix[:])
+	if err != nil {
+		return nil, err
+	}
+	if string(prefix[:len(streamMagic)]) != streamMagic {
+		return nil, ErrLegacyFormat
+	}
+	if prefix[len(streamMagic)] != streamVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	b, err := newReader(secretKey, in, int64(len(prefix)))
+	if err != nil {
+		return nil, err
+	}
+	b.salt = make([]byte, streamSaltSize)
+	_, err = io.ReadFull(in, b.salt)
+	if err != nil {
+		return nil, err
+	}
+	b.offset += streamSaltSize
+	err = deriveStreamKey(&b.sharedKey, b.salt)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewLegacyReader is like NewReader, but reads streams in the legacy format
+// written before streams were versioned. That format does not bind blocks to
+// their position in the stream, so a legacy stream that has been truncated
+// at a block boundary, or had blocks reordered, reads without error.
+func NewLegacyReader(secretKey [32]byte, in io.Reader) (*DecReader, error) {
+	b, err := newReader(secretKey, in, 0)
+	if err != nil {
+		return nil, err
+	}
+	b.legacy = true
+	return b, nil
+}
+
+// deriveStreamKey replaces key, the shared key of a stream's sender and
+// recipient, with a key unique to the stream with the given salt, using
+// HKDF-SHA256.
+func deriveStreamKey(key *[32]byte, salt []byte) error {
+	var streamKey [32]byte
+	_, err := io.ReadFull(hkdf.New(sha256.New, key[:], salt, []byte(streamKeyInfo)), streamKey[:])
+	if err != nil {
+		return err
+	}
+	copy(key[:], streamKey[:])
+	zero(streamKey[:])
+	return nil
+}
+
+// newReader reads the sender's public key from in, which is offset bytes
+// into the stream, and returns a DecReader for the blocks that follow.
+func newReader(secretKey [32]byte, in io.Reader, offset int64) (*DecReader, error) {
 	var peersPublicKey [32]byte
 	_, err := io.ReadFull(in, peersPublicKey[:])
 	if err != nil {
 		return nil, err
 	}
-	return &DecReader{
+	b := &DecReader{
 		secretKey:      secretKey,
 		peersPublicKey: peersPublicKey,
 		in:             in,
-	}, nil
+		offset:         offset + int64(len(peersPublicKey)),
+	}
+	box.Precompute(&b.sharedKey, &b.peersPublicKey, &b.secretKey)
+	return b, nil
+}
+
+// SenderPublicKey returns the public key at the start of the stream. For
+// streams written by NewWriter this is a random ephemeral key; for streams
+// written by NewWriterWithKeys it is the sender's long-term public key.
+// Blocks are only decrypted if they were sealed with the matching secret key,
+// so once Read has returned data the key can be used to identify the sender.
+func (b *DecReader) SenderPublicKey() [32]byte {
+	return b.peersPublicKey
+}
+
+// syncer is implemented by outputs, such as *os.File, that can commit
+// written data to stable storage.
+type syncer interface {
+	Sync() error
+}
+
+// SetSyncInterval causes the EncWriter to call Sync on its output every n
+// blocks, if the output implements Sync() error. If checkpoint is non-nil it
+// is called after each successful sync with the number of blocks durably
+// written so far, which can be used to record resumption points. The output
+// is also synced on Close. An interval of 0 disables syncing.
+func (w *EncWriter) SetSyncInterval(n uint64, checkpoint func(blocks uint64)) {
+	w.syncInterval = n
+	w.checkpoint = checkpoint
+}
+
+// SetDerivedNonces controls whether block nonces are derived from the
+// stream's key and the block counter instead of being read from crypto/rand.
+// Since every stream's key is derived from a fresh random salt, derived
+// nonces are unique without relying on the system RNG after NewWriter
+// returns. Readers need no configuration: the nonce is stored with each
+// block either way.
+func (w *EncWriter) SetDerivedNonces(derived bool) {
+	w.derivedNonces = derived
+}
+
+// SetCiphertextHash causes every byte of ciphertext the EncWriter emits,
+// including the stream header, to also be written to h, so callers can
+// record a digest of the stream for manifests or integrity checks without
+// wrapping the output. It must be called before the first Write; h.Sum then
+// returns the digest of the stream written so far.
+func (w *EncWriter) SetCiphertextHash(h hash.Hash) {
+	h.Write(w.header)
+	w.ciphertextHash = h
 }
 
-// Write writes the entirety of p to the underlying io.Writer, encrypting the
-// data with the public key and chunking as needed.
+// Write encrypts p with the public key, buffering plaintext until a full
+// block is available. Partial blocks are only written by Flush and Close, so
+// callers must Close the EncWriter to write the end of the stream. If writing
+// to the output fails, that error is returned by every later call to Write,
+// Flush and Close.
 func (w *EncWriter) Write(p []byte) (int, error) {
-	for i, b := range p {
+	if w.err != nil {
+		return 0, w.err
+	} else if w.finalWritten {
+		return 0, ErrWriterClosed
+	}
+	n := 0
+	for len(p) > 0 {
+		copied := copy(w.buf[len(w.buf):maxBlockSize], p)
+		w.buf = w.buf[:len(w.buf)+copied]
+		p = p[copied:]
+		n += copied
 		if len(w.buf) == maxBlockSize {
-			err := w.writeBlock()
-			if err != nil {
-				return i, err
+			err := w.writeBlock(false)
+			if err == w.err && err != nil {
+				// the block was refused or only partly written, so only the
+				// bytes of p in earlier blocks reached the output. A refusal
+				// always happens on the first block, so that is none of p.
+				return n - copied, err
+			} else if err != nil {
+				return n, err
 			}
 		}
-		w.buf = append(w.buf, b)
 	}
-	err := w.writeBlock()
-	return len(p), err
+	return n, nil
 }
 
-// writeBlock writes a block using EncWriter's buf and resets the buffer.
-func (w *EncWriter) writeBlock() error {
+// Flush writes any buffered plaintext as a partial block. Flushing often
+// increases the size of the stream, since every block carries its own nonce,
+// length and authenticator.
+func (w *EncWriter) Flush() error {
+	if w.err != nil {
+		return w.err
+	} else if w.finalWritten {
+		return ErrWriterClosed
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	return w.writeBlock(false)
+}
+
+// Close writes any buffered plaintext as the final block of the stream and,
+// if a sync interval is set, syncs the output. Readers report streams that
+// end without a final block as truncated. Close does not close the
+// underlying io.Writer. Writes after Close return ErrWriterClosed. If the
+// sync fails, Close may be called again to retry it; the final block is only
+// ever written once.
+func (w *EncWriter) Close() error {
+	if w.err == ErrWriterClosed {
+		return nil
+	} else if w.err != nil {
+		return w.err
+	}
+	if !w.finalWritten {
+		err := w.writeBlock(true)
+		if err != nil {
+			return err
+		}
+	}
+	if w.syncInterval != 0 {
+		err := w.sync()
+		if err != nil {
+			return err
+		}
+	}
+	w.err = ErrWriterClosed
+	return nil
+}
+
+// writeBlock writes a block using EncWriter's buf and resets the buffer. If
+// final is set the block is marked as the last in the stream.
+func (w *EncWriter) writeBlock(final bool) error {
+	if w.blocks == 0 && w.policy != nil {
+		err := w.policy(w.buf)
+		if err != nil {
+			zero(w.buf)
+			w.buf = w.buf[:0]
+			w.err = err
+			return err
+		}
+	}
+
 	var nonce [24]byte
-	_, err := io.ReadFull(rand.Reader, nonce[:])
-	if err != nil {
-		panic("could not read entropy for encryption")
+	if w.derivedNonces {
+		err := w.deriveNonce(&nonce)
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err := io.ReadFull(w.rand, nonce[:])
+		if err != nil {
+			return entropyError(err)
+		}
 	}
 
-	encryptedData := box.Seal(nil, w.buf, &nonce, &w.peersPublicKey, &w.secretKey)
-	w.buf = nil
+	data := w.buf
+	if w.transformer != nil {
+		encoded, err := w.transformer.Encode(w.buf)
+		if err != nil {
+			return err
+		}
+		if len(encoded) > maxTransformedBlockSize {
+			if !aliases(encoded, w.buf) {
+				zero(encoded)
+			}
+			return errors.New("transformed block exceeds maximum block size")
+		}
+		data = encoded
+	}
+	// every block seals its index and whether it ends the stream along with
+	// the data, so blocks can't be reordered, dropped or truncated without
+	// the reader noticing.
+	plaintext := make([]byte, blockHeaderSize, blockHeaderSize+len(data))
+	binary.LittleEndian.PutUint64(plaintext, w.blocks)
+	if final {
+		plaintext[8] = finalBlockFlag
+	}
+	plaintext = append(plaintext, data...)
+	// the frame is the nonce, the length of the sealed block, and the sealed
+	// block itself.
+	frame := make([]byte, len(nonce)+8, len(nonce)+8+len(plaintext)+box.Overhead)
+	copy(frame, nonce[:])
+	binary.LittleEndian.PutUint64(frame[len(nonce):], uint64(len(plaintext)+box.Overhead))
+	frame = box.SealAfterPrecomputation(frame, plaintext, &nonce, &w.sharedKey)
+	zero(plaintext)
+	zero(data)
+	zero(w.buf)
+	w.buf = w.buf[:0]
 
-	_, err = w.out.Write(nonce[:])
+	_, err := w.out.Write(frame)
 	if err != nil {
+		// part of the frame may have reached the output, so the block can't
+		// be retried without reusing its nonce.
+		w.err = err
 		return err
 	}
-	blockSize := uint64(len(encryptedData))
-	err = binary.Write(w.out, binary.LittleEndian, blockSize)
+	if w.ciphertextHash != nil {
+		w.ciphertextHash.Write(frame)
+	}
+	w.blocks++
+	// the final block is synced by Close, which can retry a failed sync
+	// without writing the block again.
+	if final {
+		w.finalWritten = true
+	} else if w.syncInterval != 0 && w.blocks%w.syncInterval == 0 {
+		return w.sync()
+	}
+	return nil
+}
+
+// deriveNonce sets nonce to HKDF-SHA256 of the stream's shared key, keyed
+// by the index of the block about to be written.
+func (w *EncWriter) deriveNonce(nonce *[24]byte) error {
+	info := make([]byte, len(nonceInfoPrefix)+8)
+	copy(info, nonceInfoPrefix)
+	binary.LittleEndian.PutUint64(info[len(nonceInfoPrefix):], w.blocks)
+	_, err := io.ReadFull(hkdf.New(sha256.New, w.sharedKey[:], nil, info), nonce[:])
+	return err
+}
+
+// sync syncs the underlying output, if possible, and notifies the checkpoint
+// callback.
+func (w *EncWriter) sync() error {
+	s, ok := w.out.(syncer)
+	if !ok {
+		return nil
+	}
+	err := s.Sync()
 	if err != nil {
 		return err
 	}
-	_, err = w.out.Write(encryptedData)
-	return err
+	if w.checkpoint != nil {
+		w.checkpoint(w.blocks)
+	}
+	return nil
 }
 
 // Read reads from the underlying io.Reader, decrypting bytes as needed, until
-// len(p) byte have been read or the underlying stream is exhausted.
+// len(p) byte have been read or the end of the stream is reached. Read
+// returns io.EOF after the final block written by EncWriter.Close, and does
+// not read past it. If the underlying stream ends before the final block,
+// Read returns a *CorruptionError wrapping ErrTruncatedStream, or, if it ends
+// part way through a block, one for which errors.Is(err, io.ErrUnexpectedEOF)
+// is true; the bytes of the partial block have been consumed, so a retry must
+// resume from the block's Offset. Blocks that are authentic but out of
+// sequence are reported with a *CorruptionError wrapping ErrReorderedStream.
 func (b *DecReader) Read(p []byte) (int, error) {
 	for i := range p {
 		if b.index == 0 {
 			err := b.nextBlock()
+			for err == nil && len(b.buf) == 0 {
+				// empty blocks carry no data, skip them.
+				err = b.nextBlock()
+			}
 			if err != nil {
-				return len(p) - i, err
+				return i, err
 			}
 		}
 		p[i] = b.buf[b.index]
@@ -133,27 +537,202 @@ func (b *DecReader) Read(p []byte) (int, error) {
 	return len(p), nil
 }
 
-// nextBlock reads the next block into DecReader's buf.
+// nextBlock reads the next block into DecReader's buf, reporting the attempt
+// to the audit function if one is set.
 func (b *DecReader) nextBlock() error {
-	var nonce [24]byte
-	_, err := io.ReadFull(b.in, nonce[:])
-	if err != nil {
-		return err
+	ciphertextBytes, err := b.readBlock()
+	// the replay check comes before the audit event, so refused replays are
+	// reported as failures rather than successful decryptions.
+	if err == nil && b.blocks == 0 && b.replayCache != nil && !b.replayCache.Record(b.StreamID()) {
+		zero(b.buf)
+		b.buf = b.buf[:0]
+		err = ErrReplayedStream
 	}
-	var blockSize uint64
-	err = binary.Read(b.in, binary.LittleEndian, &blockSize)
-	if err != nil {
-		return err
+	if b.audit != nil && err != io.EOF {
+		event := AuditEvent{
+			KeyFingerprint:  b.keyFingerprint,
+			SenderPublicKey: b.peersPublicKey,
+			StreamID:        b.StreamID(),
+			Block:           b.blocks,
+			CiphertextBytes: ciphertextBytes,
+			Err:             err,
+		}
+		if err == nil {
+			event.PlaintextBytes = len(b.buf)
+		}
+		b.audit(event)
 	}
-	blockData := make([]byte, blockSize)
-	_, err = io.ReadFull(b.in, blockData)
 	if err != nil {
 		return err
 	}
-	decryptedBytes, success := box.Open(nil, blockData, &nonce, &b.peersPublicKey, &b.secretKey)
+	b.offset += int64(ciphertextBytes)
+	b.blocks++
+	return nil
+}
+
+// readBlock reads and decrypts the next block into DecReader's buf, returning
+// the number of ciphertext bytes consumed, or io.EOF once the final block has
+// been read. Malformed, inauthentic or out of sequence blocks are reported as
+// a *CorruptionError.
+func (b *DecReader) readBlock() (int, error) {
+	if b.final {
+		return 0, io.EOF
+	}
+	var nonce [24]byte
+	n, err := io.ReadFull(b.in, nonce[:])
+	if err == io.EOF && b.legacy {
+		return n, err
+	} else if err == io.EOF {
+		cerr := b.corruption("stream ended before the final block", 0, 0, false)
+		cerr.Err = ErrTruncatedStream
+		return n, cerr
+	} else if err == io.ErrUnexpectedEOF {
+		return n, b.corruption("truncated block nonce", len(nonce), n, true)
+	} else if err != nil {
+		return n, err
+	}
+	var blockSizeBytes [8]byte
+	m, err := io.ReadFull(b.in, blockSizeBytes[:])
+	n += m
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, b.corruption("truncated block length", len(blockSizeBytes), m, true)
+	} else if err != nil {
+		return n, err
+	}
+	blockSize := binary.LittleEndian.Uint64(blockSizeBytes[:])
+	maxSize := uint64(maxBlockSize)
+	if b.transformer != nil {
+		maxSize = maxTransformedBlockSize
+	}
+	maxSize += box.Overhead
+	if !b.legacy {
+		maxSize += blockHeaderSize
+	}
+	if blockSize > maxSize {
+		return n, b.corruption(fmt.Sprintf("block length %v exceeds maximum of %v", blockSize, maxSize), 0, 0, false)
+	}
+	if b.ciphertext == nil {
+		b.ciphertext = make([]byte, 0, blockHeaderSize+maxBlockSize+box.Overhead)
+		b.buf = make([]byte, 0, blockHeaderSize+maxBlockSize)
+	}
+	if blockSize > uint64(cap(b.ciphertext)) {
+		b.ciphertext = make([]byte, 0, blockSize)
+	}
+	blockData := b.ciphertext[:blockSize]
+	m, err = io.ReadFull(b.in, blockData)
+	n += m
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, b.corruption("truncated block data", len(blockData), m, true)
+	} else if err != nil {
+		return n, err
+	}
+	// the previous block has been fully consumed, so its buffer can be
+	// zeroed and reused for this one.
+	zero(b.buf)
+	decryptedBytes, success := box.OpenAfterPrecomputation(b.buf[:0], blockData, &nonce, &b.sharedKey)
 	if !success {
-		return errors.New("could not decrypt block")
+		b.buf = b.buf[:0]
+		return n, b.corruption("block failed authentication", len(blockData), m, false)
+	}
+	final := false
+	if !b.legacy {
+		decryptedBytes, final, err = b.checkBlockHeader(decryptedBytes)
+		if err != nil {
+			b.buf = b.buf[:0]
+			return n, err
+		}
+	}
+	if b.transformer != nil {
+		decoded, err := b.transformer.Decode(decryptedBytes)
+		if err != nil {
+			zero(decryptedBytes)
+			b.buf = b.buf[:0]
+			return n, b.corruption("block transform failed: "+err.Error(), 0, 0, false)
+		}
+		if len(decoded) > maxBlockSize {
+			zero(decoded)
+			zero(decryptedBytes)
+			b.buf = b.buf[:0]
+			return n, b.corruption("transformed block exceeds maximum block size", 0, 0, false)
+		}
+		// the decoded block is moved into the reader's own buffer, which
+		// always has room for a full block, so it is zeroed and reused like
+		// any other. Whatever is left of the opened block is zeroed, as is
+		// the transformer's result if it was a separate buffer.
+		block := decryptedBytes[:cap(decryptedBytes)]
+		m := copy(block, decoded)
+		if !aliases(decoded, block) {
+			zero(decoded)
+		}
+		zero(block[m:])
+		decryptedBytes = block[:m]
 	}
 	b.buf = decryptedBytes
+	b.final = final
+	return n, nil
+}
+
+// checkBlockHeader checks the header of an opened block against the
+// DecReader's position in the stream, returning the block's data, moved to
+// the start of block so the buffer can be reused, and whether it is the final
+// block. On failure block is zeroed.
+func (b *DecReader) checkBlockHeader(block []byte) ([]byte, bool, error) {
+	if len(block) < blockHeaderSize {
+		zero(block)
+		return nil, false, b.corruption("block too short for its header", blockHeaderSize, len(block), false)
+	}
+	index := binary.LittleEndian.Uint64(block)
+	flags := block[8]
+	if index != b.blocks {
+		zero(block)
+		cerr := b.corruption(fmt.Sprintf("block has index %v", index), 0, 0, false)
+		cerr.Err = ErrReorderedStream
+		return nil, false, cerr
+	}
+	if flags&^finalBlockFlag != 0 {
+		zero(block)
+		return nil, false, b.corruption(fmt.Sprintf("unknown block flags %#x", flags), 0, 0, false)
+	}
+	data := block[:copy(block, block[blockHeaderSize:])]
+	// zero the plaintext left behind at the end of the block.
+	zero(block[len(data):])
+	return data, flags&finalBlockFlag != 0, nil
+}
+
+// corruption returns a *CorruptionError describing a problem with the block
+// currently being read.
+func (b *DecReader) corruption(reason string, expected, read int, prematureEOF bool) *CorruptionError {
+	return &CorruptionError{
+		Block:        b.blocks,
+		Offset:       b.offset,
+		Reason:       reason,
+		Expected:     expected,
+		Read:         read,
+		PrematureEOF: prematureEOF,
+	}
+}
+
+// Close zeroes the DecReader's buffered plaintext and keys. The
+// DecReader cannot be used after Close. Close does not close the underlying
+// io.Reader, except for readers created by NewResumableReader.
+func (b *DecReader) Close() error {
+	zero(b.buf)
+	b.buf = nil
+	b.index = 0
+	zero(b.secretKey[:])
+	zero(b.sharedKey[:])
+	b.closed = true
+	if r, ok := b.in.(*resumingReader); ok {
+		return r.Close()
+	}
 	return nil
 }
+
+// zero overwrites p with zeroes, so that plaintext and key material does not
+// linger in memory after use. This is best effort: the Go runtime may have
+// made copies (for example when growing a stack) that can't be reached.
+func zero(p []byte) {
+	for i := range p {
+		p[i] = 0
+	}
+}
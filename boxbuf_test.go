@@ -4,7 +4,15 @@ import (
 	"bytes"
 	"compress/gzip"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"flag"
 	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"runtime"
+	"strconv"
 	"testing"
 
 	"golang.org/x/crypto/nacl/box"
@@ -40,9 +48,6 @@ func TestSecureBuffers(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if len(encWriter.buf) > maxBlockSize*3 { // there should never be more than 3 chunks buffered in memory
-			t.Fatal("encWriter is leaking chunks")
-		}
 		n, err := encWriter.Write(test.sourceData)
 		if err != nil {
 			t.Fatal(err)
@@ -50,6 +55,10 @@ func TestSecureBuffers(t *testing.T) {
 		if n != len(test.sourceData) {
 			t.Fatal("output was not the correct length got", n, "wanted", len(test.sourceData))
 		}
+		err = encWriter.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
 		if !sufficientEntropy(result.Bytes()) {
 			t.Fatal("resulting output was not uniformly random")
 		}
@@ -62,9 +71,6 @@ func TestSecureBuffers(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if len(decReader.buf) > maxBlockSize*3 { // there should never be more than 3 chunks buffered in memory
-			t.Fatal("decReader is leaking chunks")
-		}
 		if !bytes.Equal(decryptedData, test.sourceData) {
 			t.Fatal("data decrypt mismatch got", decryptedData, "wanted", test.sourceData)
 		}
@@ -85,3 +91,820 @@ func sufficientEntropy(data []byte) bool {
 	}
 	return true
 }
+
+// TestSectionReader verifies that a stream embedded in a larger object can be
+// decrypted through an io.SectionReader.
+func TestSectionReader(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := new(bytes.Buffer)
+	container.WriteString("some leading container data")
+	start := int64(container.Len())
+	encWriter, err := NewWriter(*pk, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceData := make([]byte, maxBlockSize*2+1)
+	_, err = io.ReadFull(rand.Reader, sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write(sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := int64(container.Len())
+	container.WriteString("some trailing container data")
+
+	section := io.NewSectionReader(bytes.NewReader(container.Bytes()), start, end-start)
+	decReader, err := NewReader(*sk, section)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData := make([]byte, len(sourceData))
+	_, err = decReader.Read(decryptedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedData, sourceData) {
+		t.Fatal("data decrypt mismatch")
+	}
+}
+
+// TestFlushAndClose verifies that the EncWriter only writes partial blocks
+// when flushed or closed, and refuses writes after Close.
+func TestFlushAndClose(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Len() != streamHeaderSize {
+		t.Fatal("partial block was written before Flush")
+	}
+	err = encWriter.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flushed := result.Len()
+	if flushed == streamHeaderSize {
+		t.Fatal("Flush did not write the buffered block")
+	}
+	err = encWriter.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Len() != flushed {
+		t.Fatal("Flush with nothing buffered wrote a block")
+	}
+	_, err = encWriter.Write([]byte(" again"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encWriter.Write([]byte("late")); err != ErrWriterClosed {
+		t.Fatal("expected ErrWriterClosed after Close, got", err)
+	}
+	if err := encWriter.Close(); err != nil {
+		t.Fatal("second Close failed:", err)
+	}
+
+	decReader, err := NewReader(*sk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData, err := ioutil.ReadAll(decReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decryptedData) != "this is a test again" {
+		t.Fatal("data decrypt mismatch got", string(decryptedData))
+	}
+}
+
+// syncBuffer is a bytes.Buffer that counts calls to Sync.
+type syncBuffer struct {
+	bytes.Buffer
+	syncs int
+}
+
+func (b *syncBuffer) Sync() error {
+	b.syncs++
+	return nil
+}
+
+// TestSyncInterval verifies that the EncWriter syncs its output every n
+// blocks and on Close, and reports each checkpoint.
+func TestSyncInterval(t *testing.T) {
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(syncBuffer)
+	encWriter, err := NewWriter(*pk, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var checkpoints []uint64
+	encWriter.SetSyncInterval(2, func(blocks uint64) {
+		checkpoints = append(checkpoints, blocks)
+	})
+	for i := 0; i < 4; i++ {
+		_, err = encWriter.Write([]byte("this is a test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = encWriter.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.syncs != 2 {
+		t.Fatal("expected 2 syncs, got", out.syncs)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.syncs != 3 {
+		t.Fatal("expected Close to sync, got", out.syncs, "syncs")
+	}
+	if len(checkpoints) != 3 || checkpoints[0] != 2 || checkpoints[1] != 4 || checkpoints[2] != 5 {
+		t.Fatal("unexpected checkpoints", checkpoints)
+	}
+}
+
+// failingSyncBuffer is a syncBuffer whose first Sync fails.
+type failingSyncBuffer struct {
+	syncBuffer
+}
+
+func (b *failingSyncBuffer) Sync() error {
+	b.syncs++
+	if b.syncs == 1 {
+		return errors.New("sync failed")
+	}
+	return nil
+}
+
+// TestCloseRetrySync verifies that calling Close again after its sync fails
+// retries the sync without writing a second final block.
+func TestCloseRetrySync(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(failingSyncBuffer)
+	encWriter, err := NewWriter(*pk, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter.SetSyncInterval(1, nil)
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encWriter.Close() == nil {
+		t.Fatal("expected Close to fail when the sync fails")
+	}
+	written := out.Len()
+	if _, err = encWriter.Write([]byte("more")); err != ErrWriterClosed {
+		t.Fatal("expected ErrWriterClosed after the final block, got", err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.syncs != 2 {
+		t.Fatal("expected Close to retry the sync, got", out.syncs, "syncs")
+	}
+	if out.Len() != written {
+		t.Fatal("retried Close wrote", out.Len()-written, "more bytes")
+	}
+	_, frames := splitFrames(out.Bytes())
+	if len(frames) != 1 {
+		t.Fatal("expected a single final block, got", len(frames), "blocks")
+	}
+
+	decReader, err := NewReader(*sk, &out.Buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData, err := ioutil.ReadAll(decReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decryptedData) != "this is a test" {
+		t.Fatal("data decrypt mismatch got", string(decryptedData))
+	}
+}
+
+// TestDerivedNonces verifies that streams written with derived nonces
+// decrypt normally and never repeat a nonce.
+func TestDerivedNonces(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter.SetDerivedNonces(true)
+	sourceData := make([]byte, maxBlockSize*3)
+	_, err = io.ReadFull(rand.Reader, sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write(sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// walk the frames, checking that no nonce is repeated.
+	stream := result.Bytes()[streamHeaderSize:]
+	seen := make(map[string]bool)
+	for len(stream) > 0 {
+		nonce := string(stream[:24])
+		if seen[nonce] {
+			t.Fatal("derived nonce was reused")
+		}
+		seen[nonce] = true
+		blockSize := binary.LittleEndian.Uint64(stream[24:32])
+		stream = stream[32+blockSize:]
+	}
+
+	decReader, err := NewReader(*sk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData := make([]byte, len(sourceData))
+	_, err = decReader.Read(decryptedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedData, sourceData) {
+		t.Fatal("data decrypt mismatch")
+	}
+}
+
+// TestWriterWithKeys verifies that streams written with a static sender key
+// expose that key to the reader, and that a stream claiming another sender's
+// key does not decrypt.
+func TestWriterWithKeys(t *testing.T) {
+	senderPK, senderSK, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriterWithKeys(*senderSK, *pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter.SetDerivedNonces(true)
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := result.Bytes()
+
+	decReader, err := NewReader(*sk, bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decReader.SenderPublicKey() != *senderPK {
+		t.Fatal("DecReader did not report the sender's public key")
+	}
+	decryptedData, err := ioutil.ReadAll(decReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decryptedData) != "this is a test" {
+		t.Fatal("data decrypt mismatch got", string(decryptedData))
+	}
+
+	// a stream from another key with the sender's key in its header.
+	_, forgerSK, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := new(bytes.Buffer)
+	encWriter, err = NewWriterWithKeys(*forgerSK, *pk, forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(forged.Bytes()[len(streamMagic)+1:], senderPK[:])
+	decReader, err = NewReader(*sk, forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(decReader)
+	if err == nil {
+		t.Fatal("stream with a forged sender key decrypted")
+	}
+}
+
+// TestLegacyFormat verifies that NewReader refuses legacy unversioned
+// streams and unknown versions, and that legacy streams can still be read
+// with NewLegacyReader.
+func TestLegacyFormat(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderPK, senderSK, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sharedKey [32]byte
+	box.Precompute(&sharedKey, pk, senderSK)
+
+	// a legacy stream is the sender's public key followed by frames sealing
+	// just the data.
+	legacy := new(bytes.Buffer)
+	legacy.Write(senderPK[:])
+	for _, s := range []string{"this is ", "a test"} {
+		var nonce [24]byte
+		_, err = io.ReadFull(rand.Reader, nonce[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := make([]byte, 24+8)
+		copy(frame, nonce[:])
+		binary.LittleEndian.PutUint64(frame[24:], uint64(len(s)+box.Overhead))
+		legacy.Write(box.SealAfterPrecomputation(frame, []byte(s), &nonce, &sharedKey))
+	}
+
+	if _, err := NewReader(*sk, bytes.NewReader(legacy.Bytes())); err != ErrLegacyFormat {
+		t.Fatal("expected ErrLegacyFormat, got", err)
+	}
+	decReader, err := NewLegacyReader(*sk, bytes.NewReader(legacy.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData, err := ioutil.ReadAll(decReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decryptedData) != "this is a test" {
+		t.Fatal("data decrypt mismatch got", string(decryptedData))
+	}
+
+	future := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, future)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	future.Bytes()[len(streamMagic)] = streamVersion + 1
+	if _, err := NewReader(*sk, future); err != ErrUnsupportedVersion {
+		t.Fatal("expected ErrUnsupportedVersion, got", err)
+	}
+}
+
+// shortWriter is an io.Writer that accepts n bytes and then fails once.
+type shortWriter struct {
+	bytes.Buffer
+	n      int
+	failed bool
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if !w.failed && w.Len()+len(p) > w.n {
+		w.failed = true
+		n, _ := w.Buffer.Write(p[:w.n-w.Len()])
+		return n, errors.New("short write")
+	}
+	return w.Buffer.Write(p)
+}
+
+// TestOutputFailure verifies that output errors are sticky, so a block whose
+// frame was partly written is never resealed under the same derived nonce.
+func TestOutputFailure(t *testing.T) {
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &shortWriter{n: streamHeaderSize + 40}
+	encWriter, err := NewWriter(*pk, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter.SetDerivedNonces(true)
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flushErr := encWriter.Flush()
+	if flushErr == nil {
+		t.Fatal("expected Flush to fail")
+	}
+	written := out.Len()
+	if _, err := encWriter.Write([]byte(" again")); err != flushErr {
+		t.Fatal("expected the output error from Write, got", err)
+	}
+	if err := encWriter.Flush(); err != flushErr {
+		t.Fatal("expected the output error from Flush, got", err)
+	}
+	if err := encWriter.Close(); err != flushErr {
+		t.Fatal("expected the output error from Close, got", err)
+	}
+	if out.Len() != written {
+		t.Fatal("EncWriter kept writing after its output failed")
+	}
+
+	// Write reports the bytes of the blocks that reached the output.
+	frameSize := 24 + 8 + blockHeaderSize + maxBlockSize + box.Overhead
+	out = &shortWriter{n: streamHeaderSize + frameSize + 10}
+	encWriter, err = NewWriter(*pk, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := encWriter.Write(make([]byte, 2*maxBlockSize+5))
+	if err == nil || n != maxBlockSize {
+		t.Fatal("expected", maxBlockSize, "bytes written and an error, got", n, err)
+	}
+}
+
+// failingReader is an io.Reader that always fails.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy source failed")
+}
+
+// TestEntropyFailure verifies that failures of the random number generator
+// are returned as ErrEntropyUnavailable rather than panicking, and that the
+// EncWriter can be retried afterwards.
+func TestEntropyFailure(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, newErr := NewWriterWithRand(*pk, new(bytes.Buffer), failingReader{})
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter.rand = failingReader{}
+	flushErr := encWriter.Flush()
+	encWriter.rand = rand.Reader
+	if newErr != ErrEntropyUnavailable {
+		t.Fatal("expected ErrEntropyUnavailable from NewWriter, got", newErr)
+	}
+	if flushErr != ErrEntropyUnavailable {
+		t.Fatal("expected ErrEntropyUnavailable from Flush, got", flushErr)
+	}
+
+	// the buffered data is written by the next successful Close.
+	_, err = encWriter.Write([]byte(" again"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decReader, err := NewReader(*sk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData := make([]byte, len("this is a test again"))
+	_, err = decReader.Read(decryptedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decryptedData) != "this is a test again" {
+		t.Fatal("data decrypt mismatch got", string(decryptedData))
+	}
+}
+
+// TestDeterministicWriter verifies that writers using the same random source
+// produce identical output.
+func TestDeterministicWriter(t *testing.T) {
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := make([]byte, 32+streamSaltSize+24*3)
+	_, err = io.ReadFull(rand.Reader, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceData := make([]byte, maxBlockSize*2+1)
+	var outputs [2]bytes.Buffer
+	for i := range outputs {
+		encWriter, err := NewWriterWithRand(*pk, &outputs[i], bytes.NewReader(seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = encWriter.Write(sourceData)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(outputs[0].Bytes(), outputs[1].Bytes()) {
+		t.Fatal("writers with the same random source produced different output")
+	}
+}
+
+// TestZeroization verifies that plaintext does not remain in EncWriter and
+// DecReader buffers after use.
+func TestZeroization(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceData := bytes.Repeat([]byte{0xff}, maxBlockSize+1)
+	_, err = encWriter.Write(sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range encWriter.buf[:cap(encWriter.buf)] {
+		if b != 0 {
+			t.Fatal("EncWriter retained plaintext after sealing")
+		}
+	}
+
+	decReader, err := NewReader(*sk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decReader.Read(make([]byte, maxBlockSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := decReader.buf
+	err = decReader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range buf {
+		if b != 0 {
+			t.Fatal("DecReader retained plaintext after Close")
+		}
+	}
+	if decReader.secretKey != [32]byte{} || decReader.sharedKey != [32]byte{} {
+		t.Fatal("DecReader retained keys after Close")
+	}
+}
+
+// TestWriteAllocations verifies that writing a block does not allocate
+// significantly more than sealing it, so the hot path doesn't regress into
+// per-byte buffer growth.
+func TestWriteAllocations(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter, err := NewWriter(*pk, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := make([]byte, maxBlockSize)
+	var nonce [24]byte
+	sealAllocs := testing.AllocsPerRun(100, func() {
+		box.Seal(nil, block, &nonce, pk, sk)
+	})
+	writeAllocs := testing.AllocsPerRun(100, func() {
+		encWriter.Write(block)
+	})
+	if writeAllocs > sealAllocs+4 {
+		t.Fatalf("Write made %v allocations per block, sealing alone makes %v", writeAllocs, sealAllocs)
+	}
+}
+
+// benchmarkSizes are the write and read sizes used by the benchmarks.
+var benchmarkSizes = []int{1 << 10, maxBlockSize, 1 << 20}
+
+// BenchmarkWrite measures encryption throughput for writes of various sizes.
+func BenchmarkWrite(b *testing.B) {
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			encWriter, err := NewWriter(*pk, ioutil.Discard)
+			if err != nil {
+				b.Fatal(err)
+			}
+			data := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err = encWriter.Write(data)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRead measures decryption throughput for reads of various sizes.
+func BenchmarkRead(b *testing.B) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			stream := new(bytes.Buffer)
+			encWriter, err := NewWriter(*pk, stream)
+			if err != nil {
+				b.Fatal(err)
+			}
+			data := make([]byte, size)
+			for i := 0; i < b.N; i++ {
+				_, err = encWriter.Write(data)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			err = encWriter.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+			decReader, err := NewReader(*sk, stream)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err = decReader.Read(data)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// constantMemorySeed seeds the random write and read sizes used by
+// TestConstantMemory. A fixed default keeps failures reproducible; pass a
+// different value to explore other splits.
+var constantMemorySeed = flag.Int64("constant-memory-seed", 1, "seed for TestConstantMemory")
+
+// constantMemoryHeapGrowth bounds how far the live heap may grow while
+// TestConstantMemory streams data, well under the size of the stream.
+const constantMemoryHeapGrowth = 1 << 20
+
+// TestConstantMemory is a randomized property test verifying that streaming
+// a large amount of data from an EncWriter into a DecReader uses a constant
+// amount of memory no matter how the stream is split into writes and reads.
+// The writer and reader are connected by an io.Pipe so that no ciphertext is
+// buffered between them, and the live heap is measured as the data flows.
+func TestConstantMemory(t *testing.T) {
+	streamSize := 64 << 20
+	if testing.Short() {
+		streamSize = 4 << 20
+	}
+	t.Log("seed", *constantMemorySeed)
+	writeRng := mrand.New(mrand.NewSource(*constantMemorySeed))
+	readRng := mrand.New(mrand.NewSource(*constantMemorySeed + 1))
+
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heapAlloc := func() int64 {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		return int64(stats.HeapAlloc)
+	}
+	before := heapAlloc()
+
+	pr, pw := io.Pipe()
+	go func() {
+		encWriter, err := NewWriter(*pk, pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		data := make([]byte, 4*maxBlockSize)
+		for written := 0; written < streamSize; {
+			n := writeRng.Intn(len(data)) + 1
+			if n > streamSize-written {
+				n = streamSize - written
+			}
+			_, err = encWriter.Write(data[:n])
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			written += n
+		}
+		pw.CloseWithError(encWriter.Close())
+	}()
+
+	decReader, err := NewReader(*sk, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 4*maxBlockSize)
+	read := 0
+	for checkpoint := 0; ; {
+		n, err := decReader.Read(data[:readRng.Intn(len(data))+1])
+		read += n
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if read >= checkpoint {
+			if growth := heapAlloc() - before; growth > constantMemoryHeapGrowth {
+				t.Fatal("heap grew by", growth, "bytes after", read, "bytes read")
+			}
+			checkpoint += streamSize / 16
+		}
+	}
+	if read != streamSize {
+		t.Fatal("read", read, "bytes, expected", streamSize)
+	}
+}
+
+// TestCiphertextHash verifies that the ciphertext hash covers exactly the
+// bytes written to the output.
+func TestCiphertextHash(t *testing.T) {
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.New()
+	encWriter.SetCiphertextHash(h)
+	for i := 0; i < 3; i++ {
+		_, err = encWriter.Write(make([]byte, maxBlockSize+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256.Sum256(result.Bytes()); !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Fatal("ciphertext hash mismatch")
+	}
+}
@@ -0,0 +1,93 @@
+// Package boxbuftest provides a conformance harness for checking that
+// boxbuf stream decoders, including ports to other languages, reject
+// tampered and truncated ciphertext.
+package boxbuftest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/avahowell/boxbuf"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// DecryptFunc decrypts a complete boxbuf stream, returning an error if the
+// stream fails to authenticate.
+type DecryptFunc func(ciphertext []byte) ([]byte, error)
+
+// GenerateStream encrypts plaintext to a freshly generated key, returning the
+// stream and the secret key needed to decrypt it.
+func GenerateStream(plaintext []byte) ([]byte, [32]byte, error) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, [32]byte{}, err
+	}
+	out := new(bytes.Buffer)
+	encWriter, err := boxbuf.NewWriter(*pk, out)
+	if err != nil {
+		return nil, [32]byte{}, err
+	}
+	_, err = encWriter.Write(plaintext)
+	if err != nil {
+		return nil, [32]byte{}, err
+	}
+	err = encWriter.Close()
+	if err != nil {
+		return nil, [32]byte{}, err
+	}
+	return out.Bytes(), *sk, nil
+}
+
+// Decrypter returns a DecryptFunc that decrypts streams with this package's
+// DecReader using secretKey.
+func Decrypter(secretKey [32]byte) DecryptFunc {
+	return func(ciphertext []byte) ([]byte, error) {
+		decReader, err := boxbuf.NewReader(secretKey, bytes.NewReader(ciphertext))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(decReader)
+	}
+}
+
+// CheckTamperDetection flips a bit in each byte of ciphertext in turn and
+// checks that decrypt rejects every modified stream. It first checks that
+// the unmodified stream decrypts to plaintext. The returned error describes
+// the first offset at which tampering went undetected.
+func CheckTamperDetection(ciphertext, plaintext []byte, decrypt DecryptFunc) error {
+	result, err := decrypt(ciphertext)
+	if err != nil {
+		return fmt.Errorf("unmodified stream failed to decrypt: %v", err)
+	}
+	if !bytes.Equal(result, plaintext) {
+		return fmt.Errorf("unmodified stream decrypted to the wrong plaintext")
+	}
+	tampered := make([]byte, len(ciphertext))
+	for i := range ciphertext {
+		copy(tampered, ciphertext)
+		tampered[i] ^= 0x01
+		_, err := decrypt(tampered)
+		if err == nil {
+			return fmt.Errorf("modification of byte %v was not detected", i)
+		} else if err == io.EOF {
+			return fmt.Errorf("modification of byte %v was reported as a clean end of stream", i)
+		}
+	}
+	return nil
+}
+
+// CheckTruncationDetection checks that decrypt rejects every proper prefix of
+// ciphertext, including prefixes ending between blocks. The returned error
+// describes the first length at which truncation went undetected.
+func CheckTruncationDetection(ciphertext []byte, decrypt DecryptFunc) error {
+	for n := 0; n < len(ciphertext); n++ {
+		_, err := decrypt(ciphertext[:n])
+		if err == nil {
+			return fmt.Errorf("truncation to %v bytes was not detected", n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package boxbuftest
+
+import (
+	"testing"
+)
+
+// TestCheckTamperDetection runs the tamper harness against this package's
+// DecReader, and verifies that it catches a decoder which ignores
+// authentication.
+func TestCheckTamperDetection(t *testing.T) {
+	plaintext := make([]byte, 20000)
+	ciphertext, sk, err := GenerateStream(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckTamperDetection(ciphertext, plaintext, Decrypter(sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broken := func(ciphertext []byte) ([]byte, error) {
+		return plaintext, nil
+	}
+	if CheckTamperDetection(ciphertext, plaintext, broken) == nil {
+		t.Fatal("harness accepted a decoder that ignores tampering")
+	}
+}
+
+// TestCheckTruncationDetection runs the truncation harness against this
+// package's DecReader, and verifies that it catches a decoder which accepts
+// streams ending between blocks.
+func TestCheckTruncationDetection(t *testing.T) {
+	plaintext := make([]byte, 20000)
+	ciphertext, sk, err := GenerateStream(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckTruncationDetection(ciphertext, Decrypter(sk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lenient := func(ciphertext []byte) ([]byte, error) {
+		return nil, nil
+	}
+	if CheckTruncationDetection(ciphertext, lenient) == nil {
+		t.Fatal("harness accepted a decoder that ignores truncation")
+	}
+}
@@ -0,0 +1,206 @@
+package boxbuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// maxEntryNameLength bounds the length of entry names in a container index,
+// so a corrupt index can't cause huge allocations.
+const maxEntryNameLength = 4096
+
+// ErrEntryNotFound is returned by Container.Open when no entry has the
+// requested name.
+var ErrEntryNotFound = errors.New("no container entry with that name")
+
+// containerEntry records the name and location of one stream in a container.
+type containerEntry struct {
+	name   string
+	offset int64
+	length int64
+}
+
+// ContainerWriter writes multiple named boxbuf streams into a single object.
+// The streams are written back to back, followed by an index of entry names
+// and offsets and finally the 8 byte offset of the index. Entry names are
+// stored in the clear; only the entry contents are encrypted.
+type ContainerWriter struct {
+	out     io.Writer
+	offset  int64
+	entries []containerEntry
+	names   map[string]struct{}
+	closed  bool
+}
+
+// Container provides access to the entries of a container written by a
+// ContainerWriter.
+type Container struct {
+	in      io.ReaderAt
+	entries []containerEntry
+}
+
+// countingWriter counts the number of bytes written to the underlying
+// io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewContainerWriter initializes a new ContainerWriter writing to out.
+func NewContainerWriter(out io.Writer) *ContainerWriter {
+	return &ContainerWriter{
+		out:   out,
+		names: make(map[string]struct{}),
+	}
+}
+
+// Add encrypts the contents of r for peersPublicKey and stores the result as
+// an entry called name. If Add fails, any bytes already written for the
+// entry are left in the container unreferenced, and later entries can still
+// be added.
+func (c *ContainerWriter) Add(name string, peersPublicKey [32]byte, r io.Reader) error {
+	if c.closed {
+		return errors.New("container writer is closed")
+	}
+	if _, exists := c.names[name]; exists {
+		return errors.New("duplicate container entry name")
+	}
+	if len(name) > maxEntryNameLength {
+		return errors.New("container entry name too long")
+	}
+	cw := &countingWriter{w: c.out}
+	// whatever reached the output must be accounted for, even on failure, so
+	// the offsets of later entries stay correct.
+	defer func() {
+		c.offset += cw.n
+	}()
+	encWriter, err := NewWriter(peersPublicKey, cw)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(encWriter, r)
+	if err != nil {
+		return err
+	}
+	err = encWriter.Close()
+	if err != nil {
+		return err
+	}
+	c.entries = append(c.entries, containerEntry{
+		name:   name,
+		offset: c.offset,
+		length: cw.n,
+	})
+	c.names[name] = struct{}{}
+	return nil
+}
+
+// Close writes the container index. No entries can be added after Close.
+func (c *ContainerWriter) Close() error {
+	if c.closed {
+		return errors.New("container writer is closed")
+	}
+	c.closed = true
+	cw := &countingWriter{w: c.out}
+	err := binary.Write(cw, binary.LittleEndian, uint64(len(c.entries)))
+	if err != nil {
+		return err
+	}
+	for _, entry := range c.entries {
+		err = binary.Write(cw, binary.LittleEndian, uint64(len(entry.name)))
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(cw, entry.name)
+		if err != nil {
+			return err
+		}
+		err = binary.Write(cw, binary.LittleEndian, []uint64{uint64(entry.offset), uint64(entry.length)})
+		if err != nil {
+			return err
+		}
+	}
+	return binary.Write(c.out, binary.LittleEndian, uint64(c.offset))
+}
+
+// OpenContainer reads the index of the container stored in the first size
+// bytes of in.
+func OpenContainer(in io.ReaderAt, size int64) (*Container, error) {
+	if size < 8 {
+		return nil, errors.New("container too small")
+	}
+	var indexOffset uint64
+	err := binary.Read(io.NewSectionReader(in, size-8, 8), binary.LittleEndian, &indexOffset)
+	if err != nil {
+		return nil, err
+	}
+	if indexOffset > uint64(size-8) {
+		return nil, errors.New("container index offset out of range")
+	}
+	index := io.NewSectionReader(in, int64(indexOffset), size-8-int64(indexOffset))
+
+	var numEntries uint64
+	err = binary.Read(index, binary.LittleEndian, &numEntries)
+	if err != nil {
+		return nil, err
+	}
+	c := &Container{in: in}
+	for i := uint64(0); i < numEntries; i++ {
+		var nameLength uint64
+		err = binary.Read(index, binary.LittleEndian, &nameLength)
+		if err != nil {
+			return nil, err
+		}
+		if nameLength > maxEntryNameLength {
+			return nil, errors.New("container entry name too long")
+		}
+		name := make([]byte, nameLength)
+		_, err = io.ReadFull(index, name)
+		if err != nil {
+			return nil, err
+		}
+		var location [2]uint64
+		err = binary.Read(index, binary.LittleEndian, &location)
+		if err != nil {
+			return nil, err
+		}
+		if location[0] > indexOffset || location[1] > indexOffset-location[0] {
+			return nil, errors.New("container entry out of range")
+		}
+		c.entries = append(c.entries, containerEntry{
+			name:   string(name),
+			offset: int64(location[0]),
+			length: int64(location[1]),
+		})
+	}
+	return c, nil
+}
+
+// List returns the names of all entries in the container, sorted.
+func (c *Container) List() []string {
+	names := make([]string, 0, len(c.entries))
+	for _, entry := range c.entries {
+		names = append(names, entry.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open returns a DecReader that decrypts the entry called name using
+// secretKey.
+func (c *Container) Open(name string, secretKey [32]byte) (*DecReader, error) {
+	for _, entry := range c.entries {
+		if entry.name == name {
+			return NewReader(secretKey, io.NewSectionReader(c.in, entry.offset, entry.length))
+		}
+	}
+	return nil, ErrEntryNotFound
+}
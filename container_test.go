@@ -0,0 +1,116 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestContainer verifies that named streams can be added to a container,
+// listed, and opened by name.
+func TestContainer(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string][]byte{
+		"a.txt":   []byte("this is a test"),
+		"big.bin": make([]byte, maxBlockSize*2+1),
+		"empty":   nil,
+	}
+	_, err = io.ReadFull(rand.Reader, entries["big.bin"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	cw := NewContainerWriter(out)
+	for _, name := range []string{"big.bin", "a.txt", "empty"} {
+		err = cw.Add(name, *pk, bytes.NewReader(entries[name]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cw.Add("a.txt", *pk, bytes.NewReader(nil)) == nil {
+		t.Fatal("expected error adding a duplicate entry")
+	}
+	err = cw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenContainer(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := c.List(); !reflect.DeepEqual(names, []string{"a.txt", "big.bin", "empty"}) {
+		t.Fatal("unexpected entry names", names)
+	}
+	for name, data := range entries {
+		decReader, err := c.Open(name, *sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decryptedData := make([]byte, len(data))
+		_, err = decReader.Read(decryptedData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decryptedData, data) {
+			t.Fatal("data decrypt mismatch for", name)
+		}
+	}
+	if _, err := c.Open("missing", *sk); err != ErrEntryNotFound {
+		t.Fatal("expected ErrEntryNotFound, got", err)
+	}
+}
+
+// TestContainerFailedAdd verifies that a failed Add doesn't corrupt the
+// entries added after it, and that nothing can be added after Close.
+func TestContainerFailedAdd(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	cw := NewContainerWriter(out)
+	partial := io.MultiReader(bytes.NewReader(make([]byte, maxBlockSize*2)), failingReader{})
+	if cw.Add("broken", *pk, partial) == nil {
+		t.Fatal("expected error adding an entry from a failing reader")
+	}
+	err = cw.Add("a.txt", *pk, bytes.NewReader([]byte("this is a test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cw.Add("late", *pk, bytes.NewReader(nil)) == nil {
+		t.Fatal("expected error adding an entry after Close")
+	}
+
+	c, err := OpenContainer(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := c.List(); !reflect.DeepEqual(names, []string{"a.txt"}) {
+		t.Fatal("unexpected entry names", names)
+	}
+	decReader, err := c.Open("a.txt", *sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData, err := ioutil.ReadAll(decReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decryptedData) != "this is a test" {
+		t.Fatal("data decrypt mismatch got", string(decryptedData))
+	}
+}
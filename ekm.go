@@ -0,0 +1,56 @@
+package boxbuf
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ekmPrefix domain separates exported keying material from any other use of
+// a stream's shared key.
+const ekmPrefix = "boxbuf exporter"
+
+// exportKeyingMaterial derives length bytes from sharedKey, label and context
+// using HKDF-SHA256. The label is length prefixed so that distinct
+// (label, context) pairs never produce the same HKDF info.
+func exportKeyingMaterial(sharedKey *[32]byte, label string, context []byte, length int) ([]byte, error) {
+	if length < 0 {
+		return nil, errors.New("keying material length must not be negative")
+	}
+	info := make([]byte, 0, len(ekmPrefix)+8+len(label)+len(context))
+	info = append(info, ekmPrefix...)
+	var labelLength [8]byte
+	binary.LittleEndian.PutUint64(labelLength[:], uint64(len(label)))
+	info = append(info, labelLength[:]...)
+	info = append(info, label...)
+	info = append(info, context...)
+
+	out := make([]byte, length)
+	_, err := io.ReadFull(hkdf.New(sha256.New, sharedKey[:], nil, info), out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExportKeyingMaterial derives length bytes of keying material bound to this
+// stream from label and context, in the manner of a TLS exporter. The
+// DecReader reading this stream derives the same bytes for the same label
+// and context, so applications can use the result to bind tokens or
+// authentication to the stream. length may be at most 8160.
+func (w *EncWriter) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	return exportKeyingMaterial(&w.sharedKey, label, context, length)
+}
+
+// ExportKeyingMaterial derives length bytes of keying material bound to this
+// stream. See EncWriter.ExportKeyingMaterial. It returns ErrReaderClosed
+// after Close, since the stream's key has been zeroed.
+func (b *DecReader) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	if b.closed {
+		return nil, ErrReaderClosed
+	}
+	return exportKeyingMaterial(&b.sharedKey, label, context, length)
+}
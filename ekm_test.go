@@ -0,0 +1,71 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestExportKeyingMaterial verifies that both ends of a stream export the
+// same keying material, and that it depends on the label, context and
+// stream.
+func TestExportKeyingMaterial(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decReader, err := NewReader(*sk, bytes.NewReader(result.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent, err := encWriter.ExportKeyingMaterial("test label", []byte("context"), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := decReader.ExportKeyingMaterial("test label", []byte("context"), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sent, received) {
+		t.Fatal("exported keying material mismatch")
+	}
+
+	otherLabel, err := encWriter.ExportKeyingMaterial("test labe", []byte("lcontext"), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sent, otherLabel) {
+		t.Fatal("exported keying material does not depend on label")
+	}
+
+	otherWriter, err := NewWriter(*pk, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherStream, err := otherWriter.ExportKeyingMaterial("test label", []byte("context"), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sent, otherStream) {
+		t.Fatal("exported keying material does not depend on stream")
+	}
+
+	if _, err := encWriter.ExportKeyingMaterial("test label", nil, -1); err == nil {
+		t.Fatal("expected error for a negative length")
+	}
+	err = decReader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decReader.ExportKeyingMaterial("test label", []byte("context"), 32); err != ErrReaderClosed {
+		t.Fatal("expected ErrReaderClosed after Close, got", err)
+	}
+}
@@ -0,0 +1,94 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"sync"
+)
+
+// entropyBlockSize is the size of the blocks compared by the continuous
+// random number generator test.
+const entropyBlockSize = 16
+
+// ErrEntropyUnhealthy is returned when a random number generator wrapped by
+// NewHealthCheckedReader, or tested by CheckEntropy, produces output that
+// fails a health test. Unlike ErrEntropyUnavailable this indicates a broken
+// generator rather than a transient failure, and operations should not be
+// retried with the same source.
+var ErrEntropyUnhealthy = errors.New("random number generator failed health check")
+
+// healthCheckedReader implements a continuous random number generator test
+// over another io.Reader.
+type healthCheckedReader struct {
+	mu      sync.Mutex
+	r       io.Reader
+	prev    []byte
+	current []byte
+}
+
+// NewHealthCheckedReader wraps a random number generator with continuous
+// health tests: every read must be filled completely, and no 16 byte block
+// of output may repeat the block before it. Blocks are taken from the
+// generator's output stream regardless of how it is split across reads.
+// Failures are reported as ErrEntropyUnhealthy, and the reader fails
+// permanently once a test fails. The result is safe for concurrent use, so a
+// single reader can be shared by every writer in a process: pass it to
+// NewWriterWithRand to protect long-running processes from a generator that
+// breaks at runtime.
+func NewHealthCheckedReader(r io.Reader) io.Reader {
+	return &healthCheckedReader{r: r}
+}
+
+// Read implements io.Reader.
+func (h *healthCheckedReader) Read(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.r == nil {
+		return 0, ErrEntropyUnhealthy
+	}
+	_, err := io.ReadFull(h.r, p)
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range p {
+		h.current = append(h.current, b)
+		if len(h.current) < entropyBlockSize {
+			continue
+		}
+		if h.prev != nil && subtle.ConstantTimeCompare(h.prev, h.current) == 1 {
+			h.r = nil
+			return 0, ErrEntropyUnhealthy
+		}
+		h.prev, h.current = h.current, h.prev[:0]
+	}
+	return len(p), nil
+}
+
+// CheckEntropy runs startup health tests against the random number generator
+// r, returning ErrEntropyUnhealthy if its output is short, constant, or
+// repeats itself. It is intended to be called once when a process starts;
+// use NewHealthCheckedReader for continuous testing.
+func CheckEntropy(r io.Reader) error {
+	samples := make([]byte, 4*entropyBlockSize)
+	_, err := io.ReadFull(NewHealthCheckedReader(r), samples)
+	if err == ErrEntropyUnhealthy {
+		return err
+	} else if err != nil {
+		return ErrEntropyUnavailable
+	}
+	if bytes.Count(samples, samples[:1]) == len(samples) {
+		return ErrEntropyUnhealthy
+	}
+	return nil
+}
+
+// entropyError maps errors from a random number generator to the error
+// reported to callers.
+func entropyError(err error) error {
+	if err == ErrEntropyUnhealthy {
+		return err
+	}
+	return ErrEntropyUnavailable
+}
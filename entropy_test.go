@@ -0,0 +1,98 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestCheckEntropy verifies that the startup entropy test accepts
+// crypto/rand and rejects broken generators.
+func TestCheckEntropy(t *testing.T) {
+	if err := CheckEntropy(rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckEntropy(bytes.NewReader(make([]byte, 1024))); err != ErrEntropyUnhealthy {
+		t.Fatal("expected ErrEntropyUnhealthy for constant output, got", err)
+	}
+	if err := CheckEntropy(bytes.NewReader([]byte("short"))); err != ErrEntropyUnavailable {
+		t.Fatal("expected ErrEntropyUnavailable for short output, got", err)
+	}
+	if err := CheckEntropy(failingReader{}); err != ErrEntropyUnavailable {
+		t.Fatal("expected ErrEntropyUnavailable for failing generator, got", err)
+	}
+}
+
+// TestHealthCheckedReader verifies that an EncWriter using a health checked
+// generator reports ErrEntropyUnhealthy when the generator starts repeating
+// itself.
+func TestHealthCheckedReader(t *testing.T) {
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := make([]byte, entropyBlockSize)
+	_, err = io.ReadFull(rand.Reader, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := make([]byte, 32+streamSaltSize)
+	_, err = io.ReadFull(rand.Reader, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a generator which produces a good key and salt, then repeats the same
+	// block.
+	broken := io.MultiReader(bytes.NewReader(seed), bytes.NewReader(bytes.Repeat(block, 8)))
+	encWriter, err := NewWriterWithRand(*pk, new(bytes.Buffer), NewHealthCheckedReader(broken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3 && err == nil; i++ {
+		_, err = encWriter.Write([]byte("this is a test"))
+		if err == nil {
+			err = encWriter.Flush()
+		}
+	}
+	if err != ErrEntropyUnhealthy {
+		t.Fatal("expected ErrEntropyUnhealthy, got", err)
+	}
+}
+
+// TestHealthCheckedReaderConcurrent verifies that a single health checked
+// reader can be shared by writers on several goroutines.
+func TestHealthCheckedReaderConcurrent(t *testing.T) {
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := NewHealthCheckedReader(rand.Reader)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			encWriter, err := NewWriterWithRand(*pk, ioutil.Discard, random)
+			if err == nil {
+				_, err = encWriter.Write([]byte("this is a test"))
+			}
+			if err == nil {
+				err = encWriter.Close()
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
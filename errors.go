@@ -0,0 +1,72 @@
+package boxbuf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrLegacyFormat is returned by NewReader for streams written in the legacy
+// format, before streams carried a version header. Such streams can be read
+// with NewLegacyReader.
+var ErrLegacyFormat = errors.New("stream is in the legacy unversioned format")
+
+// ErrUnsupportedVersion is returned by NewReader for streams written in a
+// format version this package cannot read.
+var ErrUnsupportedVersion = errors.New("unsupported stream format version")
+
+// ErrTruncatedStream is wrapped by the CorruptionError returned when a
+// stream ends cleanly between blocks but before its final block, which
+// happens when blocks have been dropped from the end of the stream or the
+// EncWriter was never closed.
+var ErrTruncatedStream = errors.New("stream ended before its final block")
+
+// ErrReorderedStream is wrapped by the CorruptionError returned when an
+// authentic block appears out of sequence, because blocks of the stream have
+// been reordered, duplicated or dropped.
+var ErrReorderedStream = errors.New("stream blocks are out of sequence")
+
+// A CorruptionError is returned by DecReader when the stream is malformed,
+// truncated, or fails authentication. It records where in the stream the
+// problem was found, so that applications can log actionable diagnostics.
+type CorruptionError struct {
+	// Block is the index of the block that could not be read.
+	Block uint64
+	// Offset is the offset in the ciphertext stream at which the block
+	// starts.
+	Offset int64
+	// Reason describes what was wrong with the block.
+	Reason string
+	// Expected and Read are the number of bytes expected and actually read
+	// for the part of the block being read when the problem was found. Both
+	// are zero when lengths are not relevant to the problem.
+	Expected int
+	Read     int
+	// PrematureEOF reports whether the underlying stream ended part way
+	// through the block.
+	PrematureEOF bool
+	// Err is ErrTruncatedStream or ErrReorderedStream if the stream was
+	// truncated at a block boundary or its blocks are out of sequence, and
+	// nil otherwise.
+	Err error
+}
+
+// Error implements error.
+func (e *CorruptionError) Error() string {
+	s := fmt.Sprintf("corrupt stream: block %v at offset %v: %v", e.Block, e.Offset, e.Reason)
+	if e.Expected != 0 || e.Read != 0 {
+		s += fmt.Sprintf(" (expected %v bytes, read %v)", e.Expected, e.Read)
+	}
+	return s
+}
+
+// Unwrap returns io.ErrUnexpectedEOF if the stream ended part way through a
+// block, so that callers can use errors.Is to tell truncation in the middle
+// of a block, which may be retried once more data is available, from other
+// corruption. Otherwise it returns Err.
+func (e *CorruptionError) Unwrap() error {
+	if e.PrematureEOF {
+		return io.ErrUnexpectedEOF
+	}
+	return e.Err
+}
@@ -0,0 +1,261 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestCorruptionErrors verifies that DecReader reports the block, offset and
+// lengths involved when a stream is tampered with or truncated.
+func TestCorruptionErrors(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"first block", "second block"} {
+		_, err = encWriter.Write([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = encWriter.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream := result.Bytes()
+	secondBlockOffset := int64(streamHeaderSize + 24 + 8 + blockHeaderSize + box.Overhead + len("first block"))
+
+	tests := []struct {
+		name   string
+		stream []byte
+		want   CorruptionError
+	}{
+		{
+			name: "tampered",
+			stream: func() []byte {
+				s := append([]byte(nil), stream...)
+				s[len(s)-1] ^= 1
+				return s
+			}(),
+			want: CorruptionError{Block: 1, Offset: secondBlockOffset, Expected: blockHeaderSize + box.Overhead + len("second block"), Read: blockHeaderSize + box.Overhead + len("second block")},
+		},
+		{
+			name:   "truncated data",
+			stream: stream[:len(stream)-3],
+			want:   CorruptionError{Block: 1, Offset: secondBlockOffset, Expected: blockHeaderSize + box.Overhead + len("second block"), Read: blockHeaderSize + box.Overhead + len("second block") - 3, PrematureEOF: true},
+		},
+		{
+			name:   "truncated length",
+			stream: stream[:secondBlockOffset+24+5],
+			want:   CorruptionError{Block: 1, Offset: secondBlockOffset, Expected: 8, Read: 5, PrematureEOF: true},
+		},
+	}
+	for _, test := range tests {
+		decReader, err := NewReader(*sk, bytes.NewReader(test.stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = decReader.Read(make([]byte, len("first blocksecond block")))
+		cerr, ok := err.(*CorruptionError)
+		if !ok {
+			t.Fatal(test.name, "expected a *CorruptionError, got", err)
+		}
+		test.want.Reason = cerr.Reason
+		if *cerr != test.want {
+			t.Fatalf("%v: got %+v, wanted %+v", test.name, *cerr, test.want)
+		}
+	}
+}
+
+// TestTruncationErrors verifies that a complete stream returns io.EOF while
+// a stream ending within a block returns an error matching
+// io.ErrUnexpectedEOF.
+func TestTruncationErrors(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := result.Bytes()
+
+	for cut := 1; cut < len(stream)-streamHeaderSize; cut++ {
+		decReader, err := NewReader(*sk, bytes.NewReader(stream[:len(stream)-cut]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = decReader.Read(make([]byte, len("this is a test")))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatal("expected io.ErrUnexpectedEOF cutting", cut, "bytes, got", err)
+		}
+	}
+
+	decReader, err := NewReader(*sk, bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decReader.Read(make([]byte, len("this is a test")+1))
+	if err != io.EOF {
+		t.Fatal("expected io.EOF after the final block, got", err)
+	}
+}
+
+// splitFrames splits a stream into its header and frames.
+func splitFrames(stream []byte) ([]byte, [][]byte) {
+	var frames [][]byte
+	for rest := stream[streamHeaderSize:]; len(rest) > 0; {
+		frameSize := 24 + 8 + int(binary.LittleEndian.Uint64(rest[24:32]))
+		frames = append(frames, rest[:frameSize])
+		rest = rest[frameSize:]
+	}
+	return stream[:streamHeaderSize], frames
+}
+
+// TestStreamSequence verifies that dropping, reordering or duplicating whole
+// blocks of a stream is detected.
+func TestStreamSequence(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"first block", "second block"} {
+		_, err = encWriter.Write([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = encWriter.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	unclosed := append([]byte(nil), result.Bytes()...)
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, frames := splitFrames(result.Bytes())
+	if len(frames) != 3 {
+		t.Fatal("expected 3 frames, got", len(frames))
+	}
+	join := func(frames ...[]byte) []byte {
+		return bytes.Join(append([][]byte{header}, frames...), nil)
+	}
+
+	tests := []struct {
+		name   string
+		stream []byte
+		want   error
+	}{
+		{"unclosed", unclosed, ErrTruncatedStream},
+		{"final block dropped", join(frames[0], frames[1]), ErrTruncatedStream},
+		{"blocks swapped", join(frames[1], frames[0], frames[2]), ErrReorderedStream},
+		{"block duplicated", join(frames[0], frames[0], frames[1], frames[2]), ErrReorderedStream},
+		{"block dropped", join(frames[0], frames[2]), ErrReorderedStream},
+	}
+	for _, test := range tests {
+		decReader, err := NewReader(*sk, bytes.NewReader(test.stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(decReader)
+		if !errors.Is(err, test.want) {
+			t.Fatal(test.name, "expected", test.want, "got", err)
+		}
+	}
+
+	// data after the final block is never read.
+	decReader, err := NewReader(*sk, bytes.NewReader(join(frames[0], frames[1], frames[2], frames[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData, err := ioutil.ReadAll(decReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decryptedData) != "first blocksecond block" {
+		t.Fatal("data decrypt mismatch got", string(decryptedData))
+	}
+}
+
+// TestStreamSplicing verifies that blocks can't be moved between streams
+// sent with the same static keys.
+func TestStreamSplicing(t *testing.T) {
+	_, senderSK, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streams [2][]byte
+	var ids [2]StreamID
+	for i, blocks := range [][]string{{"pay alice ", "100"}, {"pay bob ", "1"}} {
+		result := new(bytes.Buffer)
+		encWriter, err := NewWriterWithKeys(*senderSK, *pk, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range blocks {
+			_, err = encWriter.Write([]byte(s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = encWriter.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = encWriter.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams[i] = result.Bytes()
+		ids[i] = encWriter.StreamID()
+	}
+	if ids[0] == ids[1] {
+		t.Fatal("streams with the same static keys share a stream ID")
+	}
+
+	// the header and first block of one stream with the rest of the other.
+	_, framesA := splitFrames(streams[0])
+	headerB, framesB := splitFrames(streams[1])
+	spliced := bytes.Join([][]byte{headerB, framesB[0], framesA[1], framesA[2]}, nil)
+	decReader, err := NewReader(*sk, bytes.NewReader(spliced))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData, err := ioutil.ReadAll(decReader)
+	if err == nil {
+		t.Fatal("spliced stream decrypted to", string(decryptedData))
+	}
+}
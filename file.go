@@ -0,0 +1,63 @@
+package boxbuf
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic encrypts the contents of r for peersPublicKey and writes
+// the result to path. The ciphertext is streamed to a temporary file in the
+// same directory, synced to disk and then renamed over path, so a crash never
+// leaves a partially written stream at path. The file is created with mode
+// 0600.
+func WriteFileAtomic(path string, r io.Reader, peersPublicKey [32]byte) (err error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	encWriter, err := NewWriter(peersPublicKey, f)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(encWriter, r)
+	if err != nil {
+		return err
+	}
+	err = encWriter.Close()
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	// sync the directory so the rename itself is durable. Not all platforms
+	// support opening a directory for syncing, so this is best effort.
+	if d, derr := os.Open(dir); derr == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
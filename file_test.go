@@ -0,0 +1,63 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestWriteFileAtomic verifies that WriteFileAtomic replaces the destination
+// with a decryptable stream and leaves no temporary files behind.
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boxbuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "secret.boxbuf")
+	err = ioutil.WriteFile(path, []byte("old contents"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceData := []byte("this is a test")
+	err = WriteFileAtomic(path, bytes.NewReader(sourceData), *pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatal("expected a single file in the directory, got", len(files))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	decReader, err := NewReader(*sk, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData := make([]byte, len(sourceData))
+	_, err = decReader.Read(decryptedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedData, sourceData) {
+		t.Fatal("data decrypt mismatch got", decryptedData, "wanted", sourceData)
+	}
+}
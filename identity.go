@@ -0,0 +1,323 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// identityVersion is the current version of the identity file format.
+	identityVersion = 1
+
+	// scrypt parameters used when saving passphrase protected identities.
+	identityScryptN = 1 << 15
+	identityScryptR = 8
+	identityScryptP = 1
+
+	// bounds on the scrypt parameters accepted when loading an identity, so
+	// a hostile file can't pin CPU and memory.
+	maxIdentityScryptN = 1 << 20
+	maxIdentityScryptR = 8
+	maxIdentityScryptP = 16
+)
+
+var (
+	// ErrWrongPassphrase is returned by LoadIdentity when a passphrase
+	// protected identity can't be decrypted with the supplied passphrase.
+	ErrWrongPassphrase = errors.New("wrong passphrase for identity")
+	// ErrPassphraseRequired is returned by LoadIdentity when an identity is
+	// passphrase protected but no passphrase was supplied.
+	ErrPassphraseRequired = errors.New("identity is passphrase protected")
+)
+
+// An Identity is a secret key together with the metadata needed to manage
+// it.
+type Identity struct {
+	SecretKey [32]byte
+	PublicKey [32]byte
+	Created   time.Time
+	Comment   string
+}
+
+// identityFile is the JSON encoding of an Identity.
+type identityFile struct {
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	Comment   string    `json:"comment,omitempty"`
+	PublicKey []byte    `json:"public_key"`
+	SecretKey []byte    `json:"secret_key"`
+}
+
+// encryptedIdentityFile is the JSON encoding of a passphrase protected
+// Identity. Ciphertext is the secretbox sealed identityFile.
+type encryptedIdentityFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	ScryptN    int    `json:"scrypt_n"`
+	ScryptR    int    `json:"scrypt_r"`
+	ScryptP    int    `json:"scrypt_p"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// GenerateIdentity creates a new Identity with a fresh keypair.
+func GenerateIdentity(comment string) (*Identity, error) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, ErrEntropyUnavailable
+	}
+	return &Identity{
+		SecretKey: *sk,
+		PublicKey: *pk,
+		Created:   time.Now().UTC().Truncate(time.Second),
+		Comment:   comment,
+	}, nil
+}
+
+// Save writes the identity to w. If passphrase is non-empty the identity,
+// including its metadata, is encrypted with a key derived from the
+// passphrase using scrypt.
+func (id *Identity) Save(w io.Writer, passphrase []byte) error {
+	plaintext, err := json.Marshal(identityFile{
+		Version:   identityVersion,
+		Created:   id.Created,
+		Comment:   id.Comment,
+		PublicKey: id.PublicKey[:],
+		SecretKey: id.SecretKey[:],
+	})
+	if err != nil {
+		return err
+	}
+	defer zero(plaintext)
+	if len(passphrase) == 0 {
+		_, err = w.Write(append(plaintext, '\n'))
+		return err
+	}
+
+	f := encryptedIdentityFile{
+		Version: identityVersion,
+		Salt:    make([]byte, 16),
+		ScryptN: identityScryptN,
+		ScryptR: identityScryptR,
+		ScryptP: identityScryptP,
+	}
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, f.Salt); err != nil {
+		return ErrEntropyUnavailable
+	}
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return ErrEntropyUnavailable
+	}
+	key, err := identityKey(passphrase, f.Salt, f.ScryptN, f.ScryptR, f.ScryptP)
+	if err != nil {
+		return err
+	}
+	defer zero(key[:])
+	f.Nonce = nonce[:]
+	f.Ciphertext = secretbox.Seal(nil, plaintext, &nonce, key)
+	encoded, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(encoded, '\n'))
+	return err
+}
+
+// A PassphraseProvider supplies the passphrase for a protected identity on
+// demand, so that applications such as GUIs and agents need only ask the
+// user when a passphrase is actually required. LoadIdentityWithProvider
+// zeroes the passphrase once it has been used, so Passphrase must return a
+// slice that the provider does not need afterwards.
+type PassphraseProvider interface {
+	Passphrase() ([]byte, error)
+}
+
+// PassphraseFunc adapts an ordinary function to a PassphraseProvider.
+type PassphraseFunc func() ([]byte, error)
+
+// Passphrase calls f.
+func (f PassphraseFunc) Passphrase() ([]byte, error) {
+	return f()
+}
+
+// passphraseForgetter is implemented by PassphraseProviders that cache
+// passphrases and should discard a cached passphrase that turned out to be
+// wrong.
+type passphraseForgetter interface {
+	Forget()
+}
+
+// CachingPassphraseProvider wraps a PassphraseProvider, remembering the
+// passphrase it returns for a limited time. The cache keeps its own copy of
+// the passphrase and returns a fresh copy from every call, so neither the
+// wrapped provider's buffer nor the caller's is zeroed by the cache, and
+// callers may zero what they are given.
+type CachingPassphraseProvider struct {
+	provider PassphraseProvider
+	ttl      time.Duration
+
+	mu         sync.Mutex
+	passphrase []byte
+	expires    time.Time
+}
+
+// NewCachingPassphraseProvider creates a CachingPassphraseProvider that asks
+// provider for a passphrase at most once every ttl.
+func NewCachingPassphraseProvider(provider PassphraseProvider, ttl time.Duration) *CachingPassphraseProvider {
+	return &CachingPassphraseProvider{
+		provider: provider,
+		ttl:      ttl,
+	}
+}
+
+// Passphrase implements PassphraseProvider.
+func (c *CachingPassphraseProvider) Passphrase() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.passphrase != nil && time.Now().Before(c.expires) {
+		return append([]byte(nil), c.passphrase...), nil
+	}
+	zero(c.passphrase)
+	c.passphrase = nil
+	passphrase, err := c.provider.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	// an empty passphrase is never correct, so it is not cached; otherwise
+	// the user could not be asked again until the ttl expired.
+	if len(passphrase) == 0 {
+		return nil, nil
+	}
+	c.passphrase = append(make([]byte, 0, len(passphrase)), passphrase...)
+	c.expires = time.Now().Add(c.ttl)
+	return append([]byte(nil), c.passphrase...), nil
+}
+
+// Forget discards the cached passphrase, if any. LoadIdentityWithProvider
+// calls Forget when the cached passphrase is wrong.
+func (c *CachingPassphraseProvider) Forget() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	zero(c.passphrase)
+	c.passphrase = nil
+}
+
+// LoadIdentity reads an identity written by Identity.Save from r. If the
+// identity is passphrase protected, passphrase is used to decrypt it.
+func LoadIdentity(r io.Reader, passphrase []byte) (*Identity, error) {
+	return LoadIdentityWithProvider(r, PassphraseFunc(func() ([]byte, error) {
+		return append([]byte(nil), passphrase...), nil
+	}))
+}
+
+// LoadIdentityWithProvider is like LoadIdentity, but only asks provider for
+// a passphrase if the identity is passphrase protected.
+func LoadIdentityWithProvider(r io.Reader, provider PassphraseProvider) (*Identity, error) {
+	encoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Version    int    `json:"version"`
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	err = json.Unmarshal(encoded, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Version != identityVersion {
+		return nil, errors.New("unsupported identity file version")
+	}
+
+	plaintext := encoded
+	if header.Ciphertext != nil {
+		passphrase, err := provider.Passphrase()
+		if err != nil {
+			return nil, err
+		}
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		plaintext, err = openIdentity(encoded, passphrase)
+		zero(passphrase)
+		if err == ErrWrongPassphrase {
+			if f, ok := provider.(passphraseForgetter); ok {
+				f.Forget()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer zero(plaintext)
+	}
+
+	var f identityFile
+	err = json.Unmarshal(plaintext, &f)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.SecretKey) != 32 || len(f.PublicKey) != 32 {
+		return nil, errors.New("malformed identity keys")
+	}
+	id := &Identity{
+		Created: f.Created,
+		Comment: f.Comment,
+	}
+	copy(id.SecretKey[:], f.SecretKey)
+	zero(f.SecretKey)
+	curve25519.ScalarBaseMult(&id.PublicKey, &id.SecretKey)
+	if !bytes.Equal(id.PublicKey[:], f.PublicKey) {
+		return nil, errors.New("identity public key does not match secret key")
+	}
+	return id, nil
+}
+
+// openIdentity decrypts an encrypted identity file using passphrase.
+func openIdentity(encoded []byte, passphrase []byte) ([]byte, error) {
+	var f encryptedIdentityFile
+	err := json.Unmarshal(encoded, &f)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != 24 {
+		return nil, errors.New("malformed identity nonce")
+	}
+	if f.ScryptN > maxIdentityScryptN || f.ScryptR > maxIdentityScryptR || f.ScryptP > maxIdentityScryptP {
+		return nil, errors.New("identity scrypt parameters are too expensive")
+	}
+	key, err := identityKey(passphrase, f.Salt, f.ScryptN, f.ScryptR, f.ScryptP)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key[:])
+	var nonce [24]byte
+	copy(nonce[:], f.Nonce)
+	plaintext, ok := secretbox.Open(nil, f.Ciphertext, &nonce, key)
+	if !ok {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+// identityKey derives the key protecting an identity from passphrase.
+func identityKey(passphrase, salt []byte, n, r, p int) (*[32]byte, error) {
+	derived, err := scrypt.Key(passphrase, salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], derived)
+	zero(derived)
+	return &key, nil
+}
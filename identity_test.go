@@ -0,0 +1,192 @@
+package boxbuf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestIdentityFiles verifies that identities round trip through Save and
+// LoadIdentity, with and without a passphrase.
+func TestIdentityFiles(t *testing.T) {
+	id, err := GenerateIdentity("test identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, passphrase := range [][]byte{nil, []byte("correct horse battery staple")} {
+		buf := new(bytes.Buffer)
+		err = id.Save(buf, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if passphrase != nil && strings.Contains(buf.String(), "test identity") {
+			t.Fatal("passphrase protected identity leaks its comment")
+		}
+		saved := buf.String()
+
+		loaded, err := LoadIdentity(strings.NewReader(saved), passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.SecretKey != id.SecretKey || loaded.PublicKey != id.PublicKey ||
+			!loaded.Created.Equal(id.Created) || loaded.Comment != id.Comment {
+			t.Fatal("identity did not round trip", loaded, id)
+		}
+
+		if passphrase != nil {
+			if _, err := LoadIdentity(strings.NewReader(saved), []byte("wrong")); err != ErrWrongPassphrase {
+				t.Fatal("expected ErrWrongPassphrase, got", err)
+			}
+			if _, err := LoadIdentity(strings.NewReader(saved), nil); err != ErrPassphraseRequired {
+				t.Fatal("expected ErrPassphraseRequired, got", err)
+			}
+		}
+	}
+}
+
+// TestPassphraseProvider verifies that passphrase providers are consulted
+// only for protected identities, and that cached passphrases are reused
+// until they prove wrong.
+func TestPassphraseProvider(t *testing.T) {
+	id, err := GenerateIdentity("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, protected := new(bytes.Buffer), new(bytes.Buffer)
+	if err := id.Save(plain, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := id.Save(protected, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	answer := "passphrase"
+	provider := NewCachingPassphraseProvider(PassphraseFunc(func() ([]byte, error) {
+		calls++
+		return []byte(answer), nil
+	}), time.Hour)
+
+	if _, err := LoadIdentityWithProvider(bytes.NewReader(plain.Bytes()), provider); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Fatal("provider was asked for the passphrase of an unprotected identity")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := LoadIdentityWithProvider(bytes.NewReader(protected.Bytes()), provider); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Fatal("expected the passphrase to be cached, provider called", calls, "times")
+	}
+
+	// a wrong passphrase is forgotten, so the next load asks again.
+	provider.Forget()
+	answer = "wrong"
+	if _, err := LoadIdentityWithProvider(bytes.NewReader(protected.Bytes()), provider); err != ErrWrongPassphrase {
+		t.Fatal("expected ErrWrongPassphrase, got", err)
+	}
+	answer = "passphrase"
+	if _, err := LoadIdentityWithProvider(bytes.NewReader(protected.Bytes()), provider); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Fatal("expected wrong passphrase to be forgotten, provider called", calls, "times")
+	}
+}
+
+// TestCachingPassphraseProviderCopies verifies that the cache neither zeroes
+// the wrapped provider's passphrase nor is corrupted by callers zeroing the
+// passphrase they were given.
+func TestCachingPassphraseProviderCopies(t *testing.T) {
+	stored := []byte("passphrase")
+	provider := NewCachingPassphraseProvider(PassphraseFunc(func() ([]byte, error) {
+		return stored, nil
+	}), time.Hour)
+
+	passphrase, err := provider.Passphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero(passphrase)
+	passphrase, err = provider.Passphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(passphrase) != "passphrase" {
+		t.Fatal("cached passphrase was corrupted by the caller", passphrase)
+	}
+
+	provider.Forget()
+	if string(stored) != "passphrase" {
+		t.Fatal("Forget zeroed the provider's passphrase", stored)
+	}
+}
+
+// TestEmptyPassphraseNotCached verifies that an empty passphrase is reported
+// as ErrPassphraseRequired without being cached, so the next load asks the
+// provider again.
+func TestEmptyPassphraseNotCached(t *testing.T) {
+	id, err := GenerateIdentity("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected := new(bytes.Buffer)
+	if err := id.Save(protected, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	answer := ""
+	provider := NewCachingPassphraseProvider(PassphraseFunc(func() ([]byte, error) {
+		calls++
+		return []byte(answer), nil
+	}), time.Hour)
+	if _, err := LoadIdentityWithProvider(bytes.NewReader(protected.Bytes()), provider); err != ErrPassphraseRequired {
+		t.Fatal("expected ErrPassphraseRequired, got", err)
+	}
+	answer = "passphrase"
+	if _, err := LoadIdentityWithProvider(bytes.NewReader(protected.Bytes()), provider); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatal("expected the empty passphrase not to be cached, provider called", calls, "times")
+	}
+}
+
+// TestLoadIdentityZeroesPassphrase verifies that LoadIdentityWithProvider
+// zeroes the passphrase it was given once it is done with it, while
+// LoadIdentity leaves the caller's passphrase intact.
+func TestLoadIdentityZeroesPassphrase(t *testing.T) {
+	id, err := GenerateIdentity("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected := new(bytes.Buffer)
+	if err := id.Save(protected, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	var given []byte
+	provider := PassphraseFunc(func() ([]byte, error) {
+		given = []byte("passphrase")
+		return given, nil
+	})
+	if _, err := LoadIdentityWithProvider(bytes.NewReader(protected.Bytes()), provider); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(given, make([]byte, len(given))) {
+		t.Fatal("passphrase was not zeroed", given)
+	}
+
+	passphrase := []byte("passphrase")
+	if _, err := LoadIdentity(bytes.NewReader(protected.Bytes()), passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if string(passphrase) != "passphrase" {
+		t.Fatal("LoadIdentity zeroed the caller's passphrase", passphrase)
+	}
+}
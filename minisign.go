@@ -0,0 +1,166 @@
+package boxbuf
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	untrustedCommentPrefix = "untrusted comment: "
+	trustedCommentPrefix   = "trusted comment: "
+)
+
+var (
+	// minisignAlgPrehashed identifies signatures over the BLAKE2b-512 hash of
+	// the message. This is the default for minisign >= 0.11 and the only
+	// algorithm SignMinisign produces.
+	minisignAlgPrehashed = [2]byte{'E', 'D'}
+	// minisignAlgLegacy identifies signatures over the raw message.
+	minisignAlgLegacy = [2]byte{'E', 'd'}
+
+	// ErrInvalidSignature is returned when a minisign signature does not
+	// verify against the supplied public key and data.
+	ErrInvalidSignature = errors.New("invalid minisign signature")
+)
+
+// MinisignPublicKey encodes an Ed25519 public key and its 8 byte key ID in
+// the minisign public key file format.
+func MinisignPublicKey(publicKey ed25519.PublicKey, keyID [8]byte) []byte {
+	var raw bytes.Buffer
+	raw.Write(minisignAlgLegacy[:])
+	raw.Write(keyID[:])
+	raw.Write(publicKey)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%sminisign public key %016X\n", untrustedCommentPrefix, binary.LittleEndian.Uint64(keyID[:]))
+	out.WriteString(base64.StdEncoding.EncodeToString(raw.Bytes()))
+	out.WriteString("\n")
+	return out.Bytes()
+}
+
+// ParseMinisignPublicKey decodes a minisign public key, either the full
+// two-line key file or the bare base64 line, returning the Ed25519 public key
+// and its key ID.
+func ParseMinisignPublicKey(data []byte) (ed25519.PublicKey, [8]byte, error) {
+	var keyID [8]byte
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	encoded := strings.TrimSpace(lines[len(lines)-1])
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, keyID, err
+	}
+	if len(raw) != 2+8+ed25519.PublicKeySize || !bytes.Equal(raw[:2], minisignAlgLegacy[:]) {
+		return nil, keyID, errors.New("malformed minisign public key")
+	}
+	copy(keyID[:], raw[2:10])
+	return ed25519.PublicKey(raw[10:]), keyID, nil
+}
+
+// SignMinisign reads r until EOF and returns a minisign-compatible detached
+// signature over its contents. r may be either the plaintext or the
+// ciphertext produced by an EncWriter; the signature covers whatever bytes
+// are read. trustedComment is authenticated by the signature and must not
+// contain newlines.
+func SignMinisign(privateKey ed25519.PrivateKey, keyID [8]byte, r io.Reader, trustedComment string) ([]byte, error) {
+	if strings.ContainsAny(trustedComment, "\r\n") {
+		return nil, errors.New("trusted comment must be a single line")
+	}
+	h, err := blake2b.New512(nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+	signature := ed25519.Sign(privateKey, h.Sum(nil))
+	globalSignature := ed25519.Sign(privateKey, append(signature[:len(signature):len(signature)], trustedComment...))
+
+	var raw bytes.Buffer
+	raw.Write(minisignAlgPrehashed[:])
+	raw.Write(keyID[:])
+	raw.Write(signature)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%ssignature from boxbuf secret key\n", untrustedCommentPrefix)
+	out.WriteString(base64.StdEncoding.EncodeToString(raw.Bytes()))
+	out.WriteString("\n")
+	out.WriteString(trustedCommentPrefix + trustedComment + "\n")
+	out.WriteString(base64.StdEncoding.EncodeToString(globalSignature))
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
+
+// VerifyMinisign reads r until EOF and checks it against the minisign
+// signature file sig, returning the verified trusted comment. Both prehashed
+// and legacy signatures are accepted; legacy signatures require buffering
+// the whole of r in memory.
+func VerifyMinisign(publicKey ed25519.PublicKey, keyID [8]byte, r io.Reader, sig []byte) (string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(sig))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], untrustedCommentPrefix) || !strings.HasPrefix(lines[2], trustedCommentPrefix) {
+		return "", errors.New("malformed minisign signature")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return "", err
+	}
+	if len(raw) != 2+8+ed25519.SignatureSize {
+		return "", errors.New("malformed minisign signature")
+	}
+	if !bytes.Equal(raw[2:10], keyID[:]) {
+		return "", errors.New("minisign signature was made with a different key")
+	}
+	signature := raw[10:]
+	trustedComment := strings.TrimPrefix(lines[2], trustedCommentPrefix)
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil {
+		return "", err
+	}
+
+	var message []byte
+	switch {
+	case bytes.Equal(raw[:2], minisignAlgPrehashed[:]):
+		h, err := blake2b.New512(nil)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, r)
+		if err != nil {
+			return "", err
+		}
+		message = h.Sum(nil)
+	case bytes.Equal(raw[:2], minisignAlgLegacy[:]):
+		message, err = ioutil.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("unsupported minisign signature algorithm")
+	}
+
+	if !ed25519.Verify(publicKey, message, signature) {
+		return "", ErrInvalidSignature
+	}
+	if !ed25519.Verify(publicKey, append(signature[:len(signature):len(signature)], trustedComment...), globalSignature) {
+		return "", ErrInvalidSignature
+	}
+	return trustedComment, nil
+}
@@ -0,0 +1,85 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestMinisignSignatures verifies that minisign signatures over a boxbuf
+// ciphertext round trip and that tampering with the data, the trusted
+// comment, or the key is detected.
+func TestMinisignSignatures(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyID [8]byte
+	_, err = rand.Read(keyID[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsedKey, parsedID, err := ParseMinisignPublicKey(MinisignPublicKey(publicKey, keyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsedKey.Equal(publicKey) || parsedID != keyID {
+		t.Fatal("public key did not round trip")
+	}
+
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := SignMinisign(privateKey, keyID, bytes.NewReader(ciphertext.Bytes()), "file:test.boxbuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment, err := VerifyMinisign(publicKey, keyID, bytes.NewReader(ciphertext.Bytes()), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment != "file:test.boxbuf" {
+		t.Fatal("trusted comment mismatch got", comment)
+	}
+
+	tampered := append([]byte(nil), ciphertext.Bytes()...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = VerifyMinisign(publicKey, keyID, bytes.NewReader(tampered), sig)
+	if err != ErrInvalidSignature {
+		t.Fatal("expected ErrInvalidSignature for tampered data, got", err)
+	}
+
+	tamperedSig := bytes.Replace(sig, []byte("file:test.boxbuf"), []byte("file:evil.boxbuf"), 1)
+	_, err = VerifyMinisign(publicKey, keyID, bytes.NewReader(ciphertext.Bytes()), tamperedSig)
+	if err != ErrInvalidSignature {
+		t.Fatal("expected ErrInvalidSignature for tampered comment, got", err)
+	}
+
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyMinisign(otherKey, keyID, bytes.NewReader(ciphertext.Bytes()), sig)
+	if err != ErrInvalidSignature {
+		t.Fatal("expected ErrInvalidSignature for wrong key, got", err)
+	}
+}
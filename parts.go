@@ -0,0 +1,98 @@
+package boxbuf
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+)
+
+// A Part is one part of a ciphertext stream produced by a PartWriter.
+type Part struct {
+	// Number is the 1-based index of the part, as used by multipart upload
+	// APIs.
+	Number int
+	// Data holds the ciphertext of the part. It is only valid for the
+	// duration of the upload callback.
+	Data []byte
+	// SHA256 and MD5 are digests of Data, for filling in checksum and
+	// Content-MD5 fields required by storage APIs.
+	SHA256 [sha256.Size]byte
+	MD5    [md5.Size]byte
+}
+
+// A PartWriter splits the stream written to it into fixed size parts and
+// passes each completed part, with its digests, to an upload callback. It is
+// intended to be used as the output of an EncWriter when uploading
+// ciphertext with a multipart upload API.
+type PartWriter struct {
+	buf      []byte
+	partSize int
+	parts    int
+	upload   func(Part) error
+	err      error
+}
+
+// NewPartWriter creates a PartWriter that calls upload with every partSize
+// bytes written to it. The final, possibly shorter, part is uploaded by
+// Close. If partSize is not positive, every call to Write and Close returns
+// an error.
+func NewPartWriter(partSize int, upload func(Part) error) *PartWriter {
+	if partSize <= 0 {
+		return &PartWriter{err: errors.New("PartWriter part size must be positive")}
+	}
+	return &PartWriter{
+		buf:      make([]byte, 0, partSize),
+		partSize: partSize,
+		upload:   upload,
+	}
+}
+
+// Write implements io.Writer. If an upload fails, that error is returned by
+// this and all later calls to Write and Close.
+func (p *PartWriter) Write(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	written := 0
+	for len(b) > 0 {
+		n := copy(p.buf[len(p.buf):p.partSize], b)
+		p.buf = p.buf[:len(p.buf)+n]
+		b = b[n:]
+		written += n
+		if len(p.buf) == p.partSize {
+			p.err = p.uploadPart()
+			if p.err != nil {
+				return written, p.err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close uploads any remaining data as the final part. It returns an error if
+// no data was written at all.
+func (p *PartWriter) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+	if len(p.buf) > 0 {
+		p.err = p.uploadPart()
+	} else if p.parts == 0 {
+		p.err = errors.New("no data written to PartWriter")
+	}
+	return p.err
+}
+
+// uploadPart passes the buffered data to the upload callback and resets the
+// buffer.
+func (p *PartWriter) uploadPart() error {
+	p.parts++
+	err := p.upload(Part{
+		Number: p.parts,
+		Data:   p.buf,
+		SHA256: sha256.Sum256(p.buf),
+		MD5:    md5.Sum(p.buf),
+	})
+	p.buf = p.buf[:0]
+	return err
+}
@@ -0,0 +1,86 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestPartWriter verifies that a PartWriter splits an encrypted stream into
+// parts with correct digests that reassemble into a decryptable stream.
+func TestPartWriter(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded := new(bytes.Buffer)
+	var numbers []int
+	partWriter := NewPartWriter(10000, func(part Part) error {
+		if part.SHA256 != sha256.Sum256(part.Data) || part.MD5 != md5.Sum(part.Data) {
+			t.Error("part", part.Number, "has the wrong digests")
+		}
+		numbers = append(numbers, part.Number)
+		uploaded.Write(part.Data)
+		return nil
+	})
+	encWriter, err := NewWriter(*pk, partWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceData := make([]byte, maxBlockSize*3)
+	_, err = io.ReadFull(rand.Reader, sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write(sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = partWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantParts := (uploaded.Len() + 9999) / 10000
+	if len(numbers) != wantParts || numbers[len(numbers)-1] != wantParts {
+		t.Fatal("unexpected part numbers", numbers)
+	}
+	decReader, err := NewReader(*sk, uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData := make([]byte, len(sourceData))
+	_, err = decReader.Read(decryptedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedData, sourceData) {
+		t.Fatal("data decrypt mismatch")
+	}
+}
+
+// TestPartWriterSize verifies that PartWriters with a non-positive part size
+// refuse writes instead of hanging or panicking.
+func TestPartWriterSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		partWriter := NewPartWriter(size, func(Part) error {
+			t.Fatal("part uploaded with part size", size)
+			return nil
+		})
+		if _, err := partWriter.Write([]byte("this is a test")); err == nil {
+			t.Fatal("expected Write to fail with part size", size)
+		}
+		if partWriter.Close() == nil {
+			t.Fatal("expected Close to fail with part size", size)
+		}
+	}
+}
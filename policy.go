@@ -0,0 +1,13 @@
+package boxbuf
+
+// SetContentPolicy installs a policy that inspects the plaintext of the
+// first block before anything from it is sealed. head holds one full block,
+// or less if the EncWriter is flushed or closed before a block fills. If
+// policy returns an error the buffered plaintext is discarded, and the Write,
+// Flush or Close that triggered the check and every later call return the
+// error, so gateways can refuse content they shouldn't encrypt.
+// Policies that need to transform rather than refuse content should use
+// SetBlockTransformer. policy must not modify or retain head.
+func (w *EncWriter) SetContentPolicy(policy func(head []byte) error) {
+	w.policy = policy
+}
@@ -0,0 +1,65 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestContentPolicy verifies that a content policy can refuse a stream based
+// on its first bytes, and that the refusal sticks.
+func TestContentPolicy(t *testing.T) {
+	pk, _, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errRefused := errors.New("refusing to encrypt executables")
+	policy := func(head []byte) error {
+		if bytes.HasPrefix(head, []byte("\x7fELF")) {
+			return errRefused
+		}
+		return nil
+	}
+
+	accepted := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, accepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter.SetContentPolicy(policy)
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write([]byte("\x7fELF in the middle is fine"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refused := new(bytes.Buffer)
+	encWriter, err = NewWriter(*pk, refused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter.SetContentPolicy(policy)
+	headerSize := refused.Len()
+	for i := 0; i < 2; i++ {
+		n, err := encWriter.Write(append([]byte("\x7fELF"), make([]byte, maxBlockSize)...))
+		if err != errRefused || n != 0 {
+			t.Fatal("expected refusal, got", n, err)
+		}
+	}
+	if err := encWriter.Close(); err != errRefused {
+		t.Fatal("expected refusal from Close, got", err)
+	}
+	if refused.Len() != headerSize {
+		t.Fatal("refused content was written")
+	}
+}
@@ -0,0 +1,61 @@
+package boxbuf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+// maxSecretSize bounds the plaintext a SecretProvider will return, since
+// secrets are decrypted entirely into memory.
+const maxSecretSize = 1 << 20 // 1 mb
+
+// ErrSecretTooLarge is returned by SecretProvider.DecryptEnvelope when a
+// secret's plaintext exceeds 1 MiB.
+var ErrSecretTooLarge = errors.New("secret exceeds maximum size")
+
+// A SecretProvider decrypts boxbuf encrypted secrets for a fixed identity.
+// It is designed to back secret-store integrations, such as external-secrets
+// providers and CSI drivers, which hand over an encrypted blob and expect
+// plaintext in return. A SecretProvider is safe for concurrent use.
+type SecretProvider struct {
+	secretKey [32]byte
+}
+
+// NewSecretProvider creates a SecretProvider that decrypts with id.
+func NewSecretProvider(id *Identity) *SecretProvider {
+	return &SecretProvider{secretKey: id.SecretKey}
+}
+
+// DecryptEnvelope decrypts blob, a complete boxbuf stream, returning the
+// plaintext. Decryption stops early if ctx is cancelled.
+func (p *SecretProvider) DecryptEnvelope(ctx context.Context, blob []byte) ([]byte, error) {
+	decReader, err := NewReader(p.secretKey, bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	defer decReader.Close()
+	var plaintext bytes.Buffer
+	buf := make([]byte, maxBlockSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			zero(plaintext.Bytes())
+			return nil, err
+		}
+		n, err := decReader.Read(buf)
+		plaintext.Write(buf[:n])
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			zero(plaintext.Bytes())
+			return nil, err
+		}
+		if plaintext.Len() > maxSecretSize {
+			zero(plaintext.Bytes())
+			return nil, ErrSecretTooLarge
+		}
+	}
+	zero(buf)
+	return plaintext.Bytes(), nil
+}
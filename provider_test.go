@@ -0,0 +1,44 @@
+package boxbuf
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// TestSecretProvider verifies that a SecretProvider decrypts secrets for its
+// identity and honors context cancellation.
+func TestSecretProvider(t *testing.T) {
+	id, err := GenerateIdentity("cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := new(bytes.Buffer)
+	encWriter, err := NewWriter(id.PublicKey, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write([]byte("database-password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewSecretProvider(id)
+	secret, err := p.DecryptEnvelope(context.Background(), blob.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret) != "database-password" {
+		t.Fatal("unexpected secret", string(secret))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.DecryptEnvelope(ctx, blob.Bytes()); err != context.Canceled {
+		t.Fatal("expected context.Canceled, got", err)
+	}
+}
@@ -0,0 +1,129 @@
+package boxbuf
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// RecipientKeyHeader is the request header in which clients supply the
+// base64 encoded public key that response bodies should be encrypted to.
+const RecipientKeyHeader = "Boxbuf-Recipient-Key"
+
+// ErrMissingRecipientKey is returned by EncryptResponse when the request has
+// no valid RecipientKeyHeader.
+var ErrMissingRecipientKey = errors.New("request has no valid " + RecipientKeyHeader + " header")
+
+// plaintextHeaders are response headers that describe the plaintext body
+// and are wrong once it has been encrypted.
+var plaintextHeaders = []string{
+	"Accept-Ranges",
+	"Content-Encoding",
+	"Content-Length",
+	"Content-MD5",
+	"Content-Range",
+	"Digest",
+	"ETag",
+}
+
+// encryptedBody is an http response body that encrypts another body as it
+// is read.
+type encryptedBody struct {
+	*io.PipeReader
+	upstream io.Closer
+}
+
+// Close closes both the pipe and the upstream body.
+func (b *encryptedBody) Close() error {
+	b.PipeReader.Close()
+	return b.upstream.Close()
+}
+
+// EncryptResponse replaces the body of resp with a boxbuf stream encrypted
+// to the public key in the originating request's RecipientKeyHeader. It is
+// intended for use as, or from, httputil.ReverseProxy.ModifyResponse, so
+// that upstream responses are end-to-end encrypted through the proxy tier.
+// The body is encrypted as it is streamed, so Content-Length and other
+// headers describing the plaintext body, such as Content-Encoding and ETag,
+// are removed. If the request has no recipient key ErrMissingRecipientKey
+// is returned, which makes ReverseProxy fail the request rather than send
+// plaintext.
+func EncryptResponse(resp *http.Response) error {
+	if resp.Request == nil {
+		return ErrMissingRecipientKey
+	}
+	key, err := base64.StdEncoding.DecodeString(resp.Request.Header.Get(RecipientKeyHeader))
+	if err != nil || len(key) != 32 {
+		return ErrMissingRecipientKey
+	}
+	var peersPublicKey [32]byte
+	copy(peersPublicKey[:], key)
+
+	pr, pw := io.Pipe()
+	upstream := resp.Body
+	go func() {
+		encWriter, err := NewWriter(peersPublicKey, pw)
+		if err == nil {
+			_, err = io.Copy(encWriter, upstream)
+			if err == nil {
+				err = encWriter.Close()
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	resp.Body = &encryptedBody{PipeReader: pr, upstream: upstream}
+	resp.ContentLength = -1
+	for _, header := range plaintextHeaders {
+		resp.Header.Del(header)
+	}
+	resp.Header.Set("Content-Type", "application/octet-stream")
+	return nil
+}
+
+// decryptedBody is an http request body that decrypts another body as it is
+// read.
+type decryptedBody struct {
+	*DecReader
+	upstream io.Closer
+}
+
+// Close closes the DecReader and the upstream body.
+func (b *decryptedBody) Close() error {
+	b.DecReader.Close()
+	return b.upstream.Close()
+}
+
+// DecryptRequest replaces the body of req, which must be a boxbuf stream
+// encrypted to the public key matching secretKey, with the decrypted
+// plaintext, so that upstream servers receive plaintext request bodies.
+// httputil.ReverseProxy.Director can't report errors, so proxies should use
+// DecryptRequestHandler rather than calling DecryptRequest from Director.
+func DecryptRequest(req *http.Request, secretKey [32]byte) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	decReader, err := NewReader(secretKey, req.Body)
+	if err != nil {
+		return err
+	}
+	req.Body = &decryptedBody{DecReader: decReader, upstream: req.Body}
+	req.ContentLength = -1
+	req.Header.Del("Content-Length")
+	return nil
+}
+
+// DecryptRequestHandler returns a handler that decrypts request bodies with
+// DecryptRequest before passing requests to next, typically an
+// httputil.ReverseProxy using EncryptResponse. Requests whose body is not a
+// boxbuf stream are answered with 400 Bad Request instead of being forwarded.
+func DecryptRequestHandler(secretKey [32]byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := DecryptRequest(r, secretKey)
+		if err != nil {
+			http.Error(w, "request body is not a valid boxbuf stream", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,102 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestReverseProxyEncryption verifies that a reverse proxy using
+// DecryptRequestHandler and EncryptResponse passes plaintext to the upstream
+// server, returns a response only the client can decrypt, and rejects
+// requests that aren't encrypted.
+func TestReverseProxyEncryption(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// headers describing the plaintext must not reach the client.
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("ETag", `"plaintext"`)
+		w.Write(append([]byte("echo: "), body...))
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxyPK, proxySK, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := httputil.NewSingleHostReverseProxy(upstreamURL)
+	rp.ModifyResponse = EncryptResponse
+	proxy := httptest.NewServer(DecryptRequestHandler(*proxySK, rp))
+	defer proxy.Close()
+
+	clientPK, clientSK, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestBody := new(bytes.Buffer)
+	encWriter, err := NewWriter(*proxyPK, requestBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", proxy.URL, requestBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(RecipientKeyHeader, base64.StdEncoding.EncodeToString(clientPK[:]))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("unexpected status", resp.Status)
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("ETag") != "" {
+		t.Fatal("plaintext headers were passed to the client", resp.Header)
+	}
+
+	decReader, err := NewReader(*clientSK, resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(decReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "echo: this is a test" {
+		t.Fatal("unexpected response body", string(body))
+	}
+
+	resp, err = http.Post(proxy.URL, "application/octet-stream", bytes.NewReader([]byte("short")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatal("expected 400 for an unencrypted request, got", resp.Status)
+	}
+}
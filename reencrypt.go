@@ -0,0 +1,28 @@
+package boxbuf
+
+import (
+	"io"
+)
+
+// ReEncrypt decrypts the stream read from src using secretKey and encrypts
+// it for newPeersPublicKey, writing the new stream to dst. The plaintext is
+// only ever held in memory a block at a time, and is zeroed before
+// ReEncrypt returns.
+func ReEncrypt(src io.Reader, secretKey [32]byte, dst io.Writer, newPeersPublicKey [32]byte) error {
+	decReader, err := NewReader(secretKey, src)
+	if err != nil {
+		return err
+	}
+	defer decReader.Close()
+	encWriter, err := NewWriter(newPeersPublicKey, dst)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, maxBlockSize)
+	defer zero(buf)
+	_, err = io.CopyBuffer(encWriter, decReader, buf)
+	if err != nil {
+		return err
+	}
+	return encWriter.Close()
+}
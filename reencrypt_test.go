@@ -0,0 +1,68 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestReEncrypt verifies that a stream re-encrypted to a new key decrypts to
+// the original plaintext with the new key only.
+func TestReEncrypt(t *testing.T) {
+	oldPK, oldSK, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPK, newSK, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceData := make([]byte, maxBlockSize*2+1)
+	_, err = io.ReadFull(rand.Reader, sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := new(bytes.Buffer)
+	encWriter, err := NewWriter(*oldPK, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write(sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rekeyed := new(bytes.Buffer)
+	err = ReEncrypt(original, *oldSK, rekeyed, *newPK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decReader, err := NewReader(*oldSK, bytes.NewReader(rekeyed.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decReader.Read(make([]byte, 1)); err == nil {
+		t.Fatal("re-encrypted stream could be decrypted with the old key")
+	}
+
+	decReader, err = NewReader(*newSK, rekeyed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData := make([]byte, len(sourceData))
+	_, err = decReader.Read(decryptedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedData, sourceData) {
+		t.Fatal("data decrypt mismatch")
+	}
+}
@@ -0,0 +1,74 @@
+package boxbuf
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// ErrReplayedStream is returned by DecReader.Read when its ReplayCache has
+// already seen the stream being read.
+var ErrReplayedStream = errors.New("stream has already been decrypted")
+
+// A ReplayCache records the IDs of streams that have been decrypted, so
+// that a DecReader can refuse to decrypt the same stream twice.
+type ReplayCache interface {
+	// Record marks id as seen. It returns false if id had already been seen.
+	// Record must be safe for concurrent use.
+	Record(id StreamID) bool
+}
+
+// MemoryReplayCache is an in-memory ReplayCache that remembers a bounded
+// number of the most recently seen stream IDs.
+type MemoryReplayCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	seen  map[StreamID]*list.Element
+}
+
+// defaultReplayCacheSize is the number of stream IDs remembered by a
+// MemoryReplayCache created with a size of zero or less.
+const defaultReplayCacheSize = 1 << 16
+
+// NewMemoryReplayCache creates a MemoryReplayCache remembering up to size
+// stream IDs. Once full, the least recently seen ID is forgotten, so size
+// must be large enough to cover every stream that could be replayed within
+// its useful lifetime. A size of zero or less, which would forget every ID
+// as soon as it was recorded, remembers 65536 IDs instead.
+func NewMemoryReplayCache(size int) *MemoryReplayCache {
+	if size <= 0 {
+		size = defaultReplayCacheSize
+	}
+	return &MemoryReplayCache{
+		size:  size,
+		order: list.New(),
+		seen:  make(map[StreamID]*list.Element),
+	}
+}
+
+// Record implements ReplayCache.
+func (c *MemoryReplayCache) Record(id StreamID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.seen[id]; ok {
+		c.order.MoveToFront(e)
+		return false
+	}
+	c.seen[id] = c.order.PushFront(id)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.seen, oldest.Value.(StreamID))
+	}
+	return true
+}
+
+// SetReplayCache causes the DecReader to record its stream's ID in cache and
+// refuse, with ErrReplayedStream, to return data from a stream the cache has
+// already seen. The ID is recorded only once the first block has been
+// authenticated, so forged headers can't be used to burn IDs. Streams
+// without any blocks are never recorded.
+func (b *DecReader) SetReplayCache(cache ReplayCache) {
+	b.replayCache = cache
+}
@@ -0,0 +1,83 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestReplayCache verifies that a stream can be decrypted only once when a
+// ReplayCache is in use, and that refused replays are audited as failures.
+func TestReplayCache(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write([]byte("this is a test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewMemoryReplayCache(16)
+	for i, wantErr := range []error{nil, ErrReplayedStream} {
+		decReader, err := NewReader(*sk, bytes.NewReader(result.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decReader.SetReplayCache(cache)
+		var events []AuditEvent
+		decReader.SetAuditFunc(func(e AuditEvent) {
+			events = append(events, e)
+		})
+		_, err = decReader.Read(make([]byte, len("this is a test")))
+		if err != wantErr {
+			t.Fatal("read", i, "expected", wantErr, "got", err)
+		}
+		if len(events) != 1 || events[0].Err != wantErr {
+			t.Fatal("read", i, "expected an audit event with", wantErr, "got", events)
+		}
+	}
+}
+
+// TestMemoryReplayCacheEviction verifies that MemoryReplayCache forgets the
+// least recently seen IDs once full.
+func TestMemoryReplayCacheEviction(t *testing.T) {
+	cache := NewMemoryReplayCache(2)
+	ids := []StreamID{{1}, {2}, {3}}
+	for _, id := range ids {
+		if !cache.Record(id) {
+			t.Fatal("fresh ID reported as seen")
+		}
+	}
+	if cache.Record(ids[2]) || cache.Record(ids[1]) {
+		t.Fatal("recent ID was forgotten")
+	}
+	if !cache.Record(ids[0]) {
+		t.Fatal("oldest ID was not evicted")
+	}
+}
+
+// TestMemoryReplayCacheSize verifies that a MemoryReplayCache created with a
+// non-positive size still detects replays.
+func TestMemoryReplayCacheSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		cache := NewMemoryReplayCache(size)
+		if !cache.Record(StreamID{1}) {
+			t.Fatal("fresh ID reported as seen")
+		}
+		if cache.Record(StreamID{1}) {
+			t.Fatal("replayed ID was not detected with size", size)
+		}
+	}
+}
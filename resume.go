@@ -0,0 +1,78 @@
+package boxbuf
+
+import (
+	"io"
+)
+
+// A ResumableSource provides a ciphertext stream that can be opened at any
+// offset, such as an object in a store that supports range reads. It allows
+// a DecReader to recover from transient read errors part way through a long
+// stream.
+type ResumableSource interface {
+	// OpenAt returns a reader positioned offset bytes into the stream.
+	OpenAt(offset int64) (io.ReadCloser, error)
+}
+
+// resumingReader is an io.Reader over a ResumableSource that reopens the
+// source at the current offset when a read fails.
+type resumingReader struct {
+	src        ResumableSource
+	r          io.ReadCloser
+	offset     int64
+	maxRetries int
+}
+
+// NewResumableReader creates a new DecReader that decrypts the stream
+// provided by src using secretKey. When reading from src fails with an error
+// other than io.EOF, the source is reopened at the offset of the first unread
+// byte and the read is retried, up to maxRetries consecutive times, so
+// transient failures are invisible to the caller. A maxRetries of 0, or
+// less, makes a single attempt and returns the first error. Any backoff
+// between attempts is up to the implementation of OpenAt. Close closes the
+// current reader from src.
+func NewResumableReader(secretKey [32]byte, src ResumableSource, maxRetries int) (*DecReader, error) {
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	return NewReader(secretKey, &resumingReader{
+		src:        src,
+		maxRetries: maxRetries,
+	})
+}
+
+// Read implements io.Reader.
+func (r *resumingReader) Read(p []byte) (int, error) {
+	var err error
+	for attempt := 0; attempt <= r.maxRetries; attempt++ {
+		if r.r == nil {
+			r.r, err = r.src.OpenAt(r.offset)
+			if err != nil {
+				r.r = nil
+				continue
+			}
+		}
+		var n int
+		n, err = r.r.Read(p)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		// the read failed, so reopen the source at the current offset.
+		r.r.Close()
+		r.r = nil
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, err
+}
+
+// Close closes the current reader from the source, if any.
+func (r *resumingReader) Close() error {
+	if r.r == nil {
+		return nil
+	}
+	err := r.r.Close()
+	r.r = nil
+	return err
+}
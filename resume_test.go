@@ -0,0 +1,104 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// flakySource is a ResumableSource whose readers fail after returning a
+// fixed number of bytes.
+type flakySource struct {
+	data      []byte
+	failAfter int
+	opens     int
+}
+
+// flakyReader returns an error once its budget of bytes is exhausted.
+type flakyReader struct {
+	r         io.Reader
+	remaining int
+}
+
+func (f *flakyReader) Read(p []byte) (int, error) {
+	if f.remaining == 0 {
+		return 0, errors.New("connection reset")
+	}
+	if len(p) > f.remaining {
+		p = p[:f.remaining]
+	}
+	n, err := f.r.Read(p)
+	f.remaining -= n
+	return n, err
+}
+
+func (f *flakyReader) Close() error {
+	return nil
+}
+
+func (s *flakySource) OpenAt(offset int64) (io.ReadCloser, error) {
+	s.opens++
+	return &flakyReader{r: bytes.NewReader(s.data[offset:]), remaining: s.failAfter}, nil
+}
+
+// TestResumableReader verifies that a DecReader over a ResumableSource
+// recovers from repeated transient read failures.
+func TestResumableReader(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceData := make([]byte, maxBlockSize*3)
+	_, err = io.ReadFull(rand.Reader, sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write(sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := &flakySource{data: result.Bytes(), failAfter: 1000}
+	decReader, err := NewResumableReader(*sk, src, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedData, err := ioutil.ReadAll(decReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedData, sourceData) {
+		t.Fatal("data decrypt mismatch")
+	}
+	if src.opens < len(src.data)/src.failAfter {
+		t.Fatal("expected the source to be reopened, got", src.opens, "opens")
+	}
+
+	// a source that never makes progress exhausts the retries.
+	src = &flakySource{data: result.Bytes(), failAfter: 0}
+	_, err = NewResumableReader(*sk, src, 3)
+	if err == nil || src.opens != 4 {
+		t.Fatal("expected failure after 4 attempts, got", err, "after", src.opens)
+	}
+
+	// negative retries make a single attempt rather than none.
+	src = &flakySource{data: result.Bytes(), failAfter: 0}
+	_, err = NewResumableReader(*sk, src, -1)
+	if err == nil || src.opens != 1 {
+		t.Fatal("expected failure after 1 attempt, got", err, "after", src.opens)
+	}
+}
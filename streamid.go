@@ -0,0 +1,44 @@
+package boxbuf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// streamIDPrefix domain separates stream IDs from other hashes of the
+// sender's public key.
+const streamIDPrefix = "boxbuf stream id"
+
+// A StreamID identifies a single encrypted stream. It is derived from the
+// sender's public key and the random salt at the start of the stream, which
+// are authenticated by every block, so both ends agree on the ID and it
+// cannot be altered without decryption failing. Streams in the legacy format
+// have no salt, so their ID depends on the public key alone.
+type StreamID [16]byte
+
+// String returns the stream ID in hexadecimal.
+func (id StreamID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// streamID derives the StreamID of the stream sent from senderPublicKey with
+// the given salt.
+func streamID(senderPublicKey [32]byte, salt []byte) StreamID {
+	h := sha256.New()
+	h.Write([]byte(streamIDPrefix))
+	h.Write(senderPublicKey[:])
+	h.Write(salt)
+	var id StreamID
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// StreamID returns the ID of the stream being written.
+func (w *EncWriter) StreamID() StreamID {
+	return streamID(w.publicKey, w.salt)
+}
+
+// StreamID returns the ID of the stream being read.
+func (b *DecReader) StreamID() StreamID {
+	return streamID(b.peersPublicKey, b.salt)
+}
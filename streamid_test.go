@@ -0,0 +1,38 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestStreamID verifies that both ends of a stream agree on its ID and that
+// distinct streams have distinct IDs.
+func TestStreamID(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decReader, err := NewReader(*sk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encWriter.StreamID() != decReader.StreamID() {
+		t.Fatal("stream ID mismatch", encWriter.StreamID(), decReader.StreamID())
+	}
+
+	otherWriter, err := NewWriter(*pk, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherWriter.StreamID() == encWriter.StreamID() {
+		t.Fatal("distinct streams have the same ID")
+	}
+}
@@ -0,0 +1,56 @@
+package boxbuf
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+var (
+	// ErrInvalidToken is returned by OpenToken when a token is malformed or
+	// was not sealed to the supplied key.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned by OpenToken when a token is used after its
+	// expiry time.
+	ErrTokenExpired = errors.New("token has expired")
+)
+
+// SealToken encrypts a short value, such as a session token or URL
+// parameter, for peersPublicKey. The result is URL-safe and can only be
+// opened by OpenToken before expires. Tokens are anonymous: they do not
+// identify the sender.
+func SealToken(peersPublicKey [32]byte, value []byte, expires time.Time) (string, error) {
+	message := make([]byte, 8+len(value))
+	binary.LittleEndian.PutUint64(message, uint64(expires.Unix()))
+	copy(message[8:], value)
+	sealed, err := box.SealAnonymous(nil, message, &peersPublicKey, rand.Reader)
+	if err != nil {
+		return "", ErrEntropyUnavailable
+	}
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// OpenToken decrypts a token produced by SealToken using secretKey, returning
+// ErrTokenExpired if it has expired at time now.
+func OpenToken(secretKey [32]byte, token string, now time.Time) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var publicKey [32]byte
+	curve25519.ScalarBaseMult(&publicKey, &secretKey)
+	message, ok := box.OpenAnonymous(nil, sealed, &publicKey, &secretKey)
+	if !ok || len(message) < 8 {
+		return nil, ErrInvalidToken
+	}
+	expires := time.Unix(int64(binary.LittleEndian.Uint64(message)), 0)
+	if !now.Before(expires) {
+		return nil, ErrTokenExpired
+	}
+	return message[8:], nil
+}
@@ -0,0 +1,49 @@
+package boxbuf
+
+import (
+	"crypto/rand"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TestTokens verifies that sealed tokens are URL-safe, open with the right
+// key before expiry, and are rejected otherwise.
+func TestTokens(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := SealToken(*pk, []byte("session=1234"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url.QueryEscape(token) != token {
+		t.Fatal("token is not URL-safe", token)
+	}
+
+	value, err := OpenToken(*sk, token, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "session=1234" {
+		t.Fatal("token value mismatch got", string(value))
+	}
+
+	if _, err := OpenToken(*sk, token, time.Now().Add(2*time.Hour)); err != ErrTokenExpired {
+		t.Fatal("expected ErrTokenExpired, got", err)
+	}
+
+	_, otherSK, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenToken(*otherSK, token, time.Now()); err != ErrInvalidToken {
+		t.Fatal("expected ErrInvalidToken for wrong key, got", err)
+	}
+	if _, err := OpenToken(*sk, token[:len(token)-2], time.Now()); err != ErrInvalidToken {
+		t.Fatal("expected ErrInvalidToken for truncated token, got", err)
+	}
+}
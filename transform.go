@@ -0,0 +1,71 @@
+package boxbuf
+
+// maxTransformedBlockSize is the largest block of plaintext a BlockTransformer
+// may produce from a full block, leaving room for transforms such as padding
+// or compression of incompressible data to expand their input.
+const maxTransformedBlockSize = 2 * maxBlockSize
+
+// A BlockTransformer transforms each block of plaintext before it is sealed
+// and after it is opened, for extensions such as compression, padding, or
+// content scanning. Encode receives at most one full block and may return
+// up to twice that; Decode must invert Encode and return at most one full
+// block. Neither method may modify its input, though the result may alias it.
+type BlockTransformer interface {
+	Encode(block []byte) ([]byte, error)
+	Decode(block []byte) ([]byte, error)
+}
+
+// A TransformPipeline composes BlockTransformers. Encode applies them in
+// order and Decode applies them in reverse order.
+type TransformPipeline []BlockTransformer
+
+// Encode implements BlockTransformer.
+func (p TransformPipeline) Encode(block []byte) ([]byte, error) {
+	var err error
+	for _, t := range p {
+		block, err = t.Encode(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+// Decode implements BlockTransformer.
+func (p TransformPipeline) Decode(block []byte) ([]byte, error) {
+	var err error
+	for i := len(p) - 1; i >= 0; i-- {
+		block, err = p[i].Decode(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+// SetBlockTransformer causes the EncWriter to pass each block of plaintext
+// through t before sealing it. The transform is not recorded in the stream;
+// readers must be configured with the same transform. Buffers produced by t
+// are zeroed after sealing, but any intermediate buffers t allocates are its
+// own responsibility.
+func (w *EncWriter) SetBlockTransformer(t BlockTransformer) {
+	w.transformer = t
+}
+
+// SetBlockTransformer causes the DecReader to pass each opened block through
+// t's Decode method. It must match the transform the stream was written with.
+// Blocks returned by Decode are copied into the DecReader's own buffer and
+// then zeroed, as are the opened blocks they were decoded from.
+func (b *DecReader) SetBlockTransformer(t BlockTransformer) {
+	b.transformer = t
+}
+
+// aliases reports whether a and b share a backing array. Slices of the same
+// buffer share the end of their capacity, which is compared rather than
+// their starts so that aliasing is detected wherever a slice begins.
+func aliases(a, b []byte) bool {
+	if cap(a) == 0 || cap(b) == 0 {
+		return false
+	}
+	return &a[:cap(a)][cap(a)-1] == &b[:cap(b)][cap(b)-1]
+}
@@ -0,0 +1,202 @@
+package boxbuf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// checksumTransformer appends a CRC32 to each block, verifying it on Decode.
+type checksumTransformer struct{}
+
+func (checksumTransformer) Encode(block []byte) ([]byte, error) {
+	sum := crc32.ChecksumIEEE(block)
+	return append(append([]byte(nil), block...), byte(sum), byte(sum>>8), byte(sum>>16), byte(sum>>24)), nil
+}
+
+func (checksumTransformer) Decode(block []byte) ([]byte, error) {
+	if len(block) < 4 {
+		return nil, errors.New("block too short")
+	}
+	data, sum := block[:len(block)-4], block[len(block)-4:]
+	if crc32.ChecksumIEEE(data) != uint32(sum[0])|uint32(sum[1])<<8|uint32(sum[2])<<16|uint32(sum[3])<<24 {
+		return nil, errors.New("checksum mismatch")
+	}
+	return data, nil
+}
+
+// invertTransformer inverts every bit of each block.
+type invertTransformer struct{}
+
+func (invertTransformer) Encode(block []byte) ([]byte, error) {
+	out := make([]byte, len(block))
+	for i, b := range block {
+		out[i] = ^b
+	}
+	return out, nil
+}
+
+func (t invertTransformer) Decode(block []byte) ([]byte, error) {
+	return t.Encode(block)
+}
+
+// TestBlockTransformer verifies that streams written through a transform
+// pipeline decrypt when the reader uses the same pipeline, and fail when it
+// does not.
+func TestBlockTransformer(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := TransformPipeline{invertTransformer{}, checksumTransformer{}}
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter.SetBlockTransformer(pipeline)
+	sourceData := make([]byte, maxBlockSize*2+1)
+	_, err = io.ReadFull(rand.Reader, sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encWriter.Write(sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decReader, err := NewReader(*sk, bytes.NewReader(result.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decReader.SetBlockTransformer(pipeline)
+	decryptedData := make([]byte, len(sourceData))
+	_, err = decReader.Read(decryptedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedData, sourceData) {
+		t.Fatal("data decrypt mismatch")
+	}
+
+	decReader, err = NewReader(*sk, bytes.NewReader(result.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decReader.SetBlockTransformer(TransformPipeline{checksumTransformer{}, invertTransformer{}})
+	_, err = decReader.Read(decryptedData)
+	if err == nil {
+		t.Fatal("expected mismatched pipeline to fail")
+	}
+}
+
+// recordingTransformer inverts each block like invertTransformer, keeping
+// every buffer it returns so tests can check that they were zeroed.
+type recordingTransformer struct {
+	outputs [][]byte
+}
+
+func (r *recordingTransformer) Encode(block []byte) ([]byte, error) {
+	out, _ := invertTransformer{}.Encode(block)
+	r.outputs = append(r.outputs, out)
+	return out, nil
+}
+
+func (r *recordingTransformer) Decode(block []byte) ([]byte, error) {
+	return r.Encode(block)
+}
+
+// oversizeTransformer expands each block beyond maxTransformedBlockSize.
+type oversizeTransformer struct {
+	out []byte
+}
+
+func (o *oversizeTransformer) Encode(block []byte) ([]byte, error) {
+	o.out = bytes.Repeat(append([]byte(nil), block...), maxTransformedBlockSize/len(block)+1)
+	return o.out, nil
+}
+
+func (o *oversizeTransformer) Decode(block []byte) ([]byte, error) {
+	return block, nil
+}
+
+// TestBlockTransformerZeroes verifies that the buffers returned by a
+// BlockTransformer are zeroed once the EncWriter and DecReader are done with
+// them, including when the transformed block is too large to seal.
+func TestBlockTransformerZeroes(t *testing.T) {
+	pk, sk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isZero := func(p []byte) bool {
+		return bytes.Equal(p, make([]byte, len(p)))
+	}
+
+	encTransformer := new(recordingTransformer)
+	result := new(bytes.Buffer)
+	encWriter, err := NewWriter(*pk, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter.SetBlockTransformer(encTransformer)
+	sourceData := bytes.Repeat([]byte("plaintext"), maxBlockSize/4)
+	_, err = encWriter.Write(sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range encTransformer.outputs {
+		if !isZero(out) {
+			t.Fatal("encoded block was not zeroed")
+		}
+	}
+
+	decTransformer := new(recordingTransformer)
+	decReader, err := NewReader(*sk, bytes.NewReader(result.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decReader.SetBlockTransformer(decTransformer)
+	decryptedData, err := ioutil.ReadAll(decReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedData, sourceData) {
+		t.Fatal("data decrypt mismatch")
+	}
+	for _, out := range decTransformer.outputs {
+		if !isZero(out) {
+			t.Fatal("decoded block was not zeroed")
+		}
+	}
+
+	oversize := new(oversizeTransformer)
+	encWriter, err = NewWriter(*pk, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encWriter.SetBlockTransformer(oversize)
+	_, err = encWriter.Write([]byte("plaintext"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encWriter.Flush() == nil {
+		t.Fatal("expected oversize block to be rejected")
+	}
+	if !isZero(oversize.out) {
+		t.Fatal("oversize encoded block was not zeroed")
+	}
+}